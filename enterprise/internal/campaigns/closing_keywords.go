@@ -0,0 +1,46 @@
+package campaigns
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// closingKeywordRe matches the closing keywords GitHub and GitLab both recognize in PR/MR
+// descriptions (close, closes, closed, fix, fixes, fixed, resolve, resolves, resolved),
+// case-insensitively. The \b boundaries keep it from matching inside a longer word, e.g.
+// "fixxx" or "prefixes".
+var closingKeywordRe = regexp.MustCompile(`(?i)\b(?:close[sd]?|fix(?:e[sd])?|resolve[sd]?)\b`)
+
+// issueRefRe matches a single "#<digits>" reference immediately following a closing keyword,
+// allowing for comma- and "and"-separated lists ("fixes #1, #2, and #3"). It is meant to be
+// applied repeatedly against the remainder of the string, consuming one reference at a time.
+var issueRefRe = regexp.MustCompile(`^(?:\s*,?\s*(?:and)?\s*)#(\d+)`)
+
+// ParseClosingKeywordIssueRefs scans body for closing-keyword issue references (GitHub- and
+// GitLab-style "fixes #123", "Closes #1, #2 and #3", etc.) and returns the referenced issue
+// numbers in the order they appear, with duplicates removed. It is pure and side-effect free
+// so that RecomputeChangesetIssueLinks can be unit tested without a database.
+func ParseClosingKeywordIssueRefs(body string) []int64 {
+	var refs []int64
+	seen := make(map[int64]bool)
+
+	for _, m := range closingKeywordRe.FindAllStringIndex(body, -1) {
+		rest := body[m[1]:]
+		for {
+			loc := issueRefRe.FindStringSubmatchIndex(rest)
+			if loc == nil {
+				break
+			}
+
+			n, err := strconv.ParseInt(rest[loc[2]:loc[3]], 10, 64)
+			if err == nil && !seen[n] {
+				seen[n] = true
+				refs = append(refs, n)
+			}
+
+			rest = rest[loc[1]:]
+		}
+	}
+
+	return refs
+}
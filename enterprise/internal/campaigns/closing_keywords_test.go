@@ -0,0 +1,55 @@
+package campaigns
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseClosingKeywordIssueRefs(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []int64
+	}{
+		{
+			name: "single fixes",
+			body: "This fixes #123.",
+			want: []int64{123},
+		},
+		{
+			name: "case insensitive and past tense",
+			body: "Closed #1 and Resolved #2",
+			want: []int64{1, 2},
+		},
+		{
+			name: "comma and and separated list",
+			body: "fixes #1, #2, and #3",
+			want: []int64{1, 2, 3},
+		},
+		{
+			name: "does not match inside a longer word",
+			body: "This fixxx a bug but does not close #5 yet, it prefixes things.",
+			want: []int64{5},
+		},
+		{
+			name: "deduplicates repeated references",
+			body: "fixes #9\n\nAlso closes #9 again.",
+			want: []int64{9},
+		},
+		{
+			name: "no keywords",
+			body: "See #1 for context, no keyword here.",
+			want: nil,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			have := ParseClosingKeywordIssueRefs(tc.body)
+			if diff := cmp.Diff(tc.want, have); diff != "" {
+				t.Fatalf("wrong refs for body %q: %s", tc.body, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package campaigns
+
+import (
+	"testing"
+
+	cmpgn "github.com/sourcegraph/sourcegraph/internal/campaigns"
+)
+
+func TestIsAuthorBlocked(t *testing.T) {
+	policies := []*cmpgn.CampaignActorPolicy{
+		{ExternalServiceType: "github", ExternalLogin: "spambot", Mode: cmpgn.ActorPolicyModeBlock},
+		{ExternalServiceType: "github", ExternalLogin: "renovate", Mode: cmpgn.ActorPolicyModeBlock},
+	}
+
+	if !IsAuthorBlocked(policies, "github", "spambot") {
+		t.Fatal("expected spambot to be blocked")
+	}
+	if IsAuthorBlocked(policies, "github", "mrnugget") {
+		t.Fatal("expected mrnugget to not be blocked")
+	}
+	if IsAuthorBlocked(policies, "gitlab", "spambot") {
+		t.Fatal("expected a different external service type to not match")
+	}
+}
+
+func TestIsAuthorBlockedAllowOverridesBlock(t *testing.T) {
+	policies := []*cmpgn.CampaignActorPolicy{
+		{ExternalServiceType: "github", ExternalLogin: "renovate", Mode: cmpgn.ActorPolicyModeBlock},
+		{ExternalServiceType: "github", ExternalLogin: "renovate", Mode: cmpgn.ActorPolicyModeAllow},
+	}
+
+	if IsAuthorBlocked(policies, "github", "renovate") {
+		t.Fatal("expected the allow rule to override the block rule")
+	}
+}
@@ -0,0 +1,242 @@
+package campaigns
+
+import (
+	"context"
+
+	"github.com/keegancsmith/sqlf"
+	"github.com/lib/pq"
+	cmpgn "github.com/sourcegraph/sourcegraph/internal/campaigns"
+	"github.com/sourcegraph/sourcegraph/internal/db/basestore"
+	"github.com/sourcegraph/sourcegraph/internal/extsvc/bitbucketserver"
+	"github.com/sourcegraph/sourcegraph/internal/extsvc/github"
+	"github.com/sourcegraph/sourcegraph/internal/extsvc/gitlab"
+)
+
+// This file assumes a migration has added the following table. Its rows are written by
+// upsertChangesetExcerpt; CreateChangeset and UpdateChangeset do not write it:
+//
+//   CREATE TABLE changeset_excerpts (
+//       id                BIGINT PRIMARY KEY REFERENCES changesets(id) ON DELETE CASCADE,
+//       repo_id           INTEGER NOT NULL,
+//       title             TEXT NOT NULL DEFAULT '',
+//       author_login      TEXT NOT NULL DEFAULT '',
+//       external_state    TEXT,
+//       review_state      TEXT,
+//       check_state       TEXT,
+//       publication_state TEXT NOT NULL,
+//       reconciler_state  TEXT NOT NULL,
+//       updated_at        TIMESTAMPTZ NOT NULL,
+//       num_comments      INTEGER NOT NULL DEFAULT 0,
+//       campaign_ids      BIGINT[] NOT NULL DEFAULT '{}',
+//       labels            TEXT[] NOT NULL DEFAULT '{}',
+//       edit_lamport      BIGINT NOT NULL DEFAULT 0,
+//       search_tsv        tsvector  -- the weighted column described in store_changesets_textsearch.go
+//   );
+//   CREATE INDEX changeset_excerpts_labels ON changeset_excerpts USING GIN (labels);
+//   CREATE INDEX changeset_excerpts_search_tsv ON changeset_excerpts USING GIN (search_tsv);
+
+// changesetTitleAndAuthor extracts the title and author login a changeset excerpt should carry
+// from the code-host-specific metadata on cs.Metadata. It returns empty strings for metadata
+// shapes it doesn't recognize (e.g. a changeset spec that hasn't been published yet).
+func changesetTitleAndAuthor(cs *cmpgn.Changeset) (title, authorLogin string) {
+	switch m := cs.Metadata.(type) {
+	case *github.PullRequest:
+		return m.Title, m.Author.Login
+	case *gitlab.MergeRequest:
+		return m.Title, ""
+	case *bitbucketserver.PullRequest:
+		return m.Title, ""
+	default:
+		return "", ""
+	}
+}
+
+// changesetLabels extracts the set of labels attached to the changeset's upstream pull
+// request or merge request, if any. Bitbucket Server has no concept of PR labels, so it
+// returns nil for it, as for any metadata shape it doesn't recognize.
+func changesetLabels(cs *cmpgn.Changeset) []string {
+	switch m := cs.Metadata.(type) {
+	case *github.PullRequest:
+		labels := make([]string, 0, len(m.Labels.Nodes))
+		for _, l := range m.Labels.Nodes {
+			labels = append(labels, l.Name)
+		}
+		return labels
+	case *gitlab.MergeRequest:
+		return m.Labels
+	default:
+		return nil
+	}
+}
+
+// excerptFromChangeset projects the columns of a ChangesetExcerpt out of a full Changeset.
+func excerptFromChangeset(cs *cmpgn.Changeset) *cmpgn.ChangesetExcerpt {
+	title, authorLogin := changesetTitleAndAuthor(cs)
+
+	return &cmpgn.ChangesetExcerpt{
+		ID:               cs.ID,
+		RepoID:           cs.RepoID,
+		Title:            title,
+		AuthorLogin:      authorLogin,
+		ExternalState:    cs.ExternalState,
+		ReviewState:      cs.ExternalReviewState,
+		CheckState:       cs.ExternalCheckState,
+		PublicationState: cs.PublicationState,
+		ReconcilerState:  cs.ReconcilerState,
+		UpdatedAt:        cs.UpdatedAt,
+		CampaignIDs:      cs.CampaignIDs,
+		Labels:           changesetLabels(cs),
+	}
+}
+
+// upsertChangesetExcerpt writes (or rewrites) the excerpt row for cs. CreateChangeset and
+// UpdateChangeset do not call it, so writing a changesets row does not by itself update the
+// excerpt row derived from it.
+//
+// editLamport is the Lamport clock value (see Store.NextLamport) of the write producing cs.
+// The column is only ever advanced, via GREATEST, so that replaying an older write (as
+// RebuildChangesetExcerpts does when it doesn't know any better and passes 0) can never regress
+// a changeset's recorded clock.
+func (s *Store) upsertChangesetExcerpt(ctx context.Context, cs *cmpgn.Changeset, editLamport int64) error {
+	excerpt := excerptFromChangeset(cs)
+	excerpt.EditLamport = editLamport
+
+	return s.Store.Exec(ctx, sqlf.Sprintf(`
+		INSERT INTO changeset_excerpts (
+			id, repo_id, title, author_login, external_state, review_state, check_state,
+			publication_state, reconciler_state, updated_at, num_comments, campaign_ids, labels,
+			edit_lamport
+		)
+		VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s)
+		ON CONFLICT (id) DO UPDATE SET
+			repo_id           = EXCLUDED.repo_id,
+			title             = EXCLUDED.title,
+			author_login      = EXCLUDED.author_login,
+			external_state    = EXCLUDED.external_state,
+			review_state      = EXCLUDED.review_state,
+			check_state       = EXCLUDED.check_state,
+			publication_state = EXCLUDED.publication_state,
+			reconciler_state  = EXCLUDED.reconciler_state,
+			updated_at        = EXCLUDED.updated_at,
+			num_comments      = EXCLUDED.num_comments,
+			campaign_ids      = EXCLUDED.campaign_ids,
+			labels            = EXCLUDED.labels,
+			edit_lamport      = GREATEST(changeset_excerpts.edit_lamport, EXCLUDED.edit_lamport)
+	`,
+		excerpt.ID,
+		excerpt.RepoID,
+		excerpt.Title,
+		excerpt.AuthorLogin,
+		excerpt.ExternalState,
+		excerpt.ReviewState,
+		excerpt.CheckState,
+		excerpt.PublicationState,
+		excerpt.ReconcilerState,
+		excerpt.UpdatedAt,
+		excerpt.NumComments,
+		pq.Array(excerpt.CampaignIDs),
+		pq.Array(excerpt.Labels),
+		excerpt.EditLamport,
+	))
+}
+
+// ListChangesetExcerpts returns the slim excerpt projection for every changeset matching opts,
+// without ever touching the changesets table's Metadata column. It supports the same filters
+// as ListChangesets; callers that only need excerpt fields (e.g. the campaigns UI's changeset
+// list) should prefer this over ListChangesets.
+//
+// search is optional; its zero value matches every row. See ChangesetSearchOpts for why it is
+// a separate parameter rather than fields on ListChangesetsOpts itself.
+func (s *Store) ListChangesetExcerpts(ctx context.Context, opts ListChangesetsOpts, search ChangesetSearchOpts) (_ []*cmpgn.ChangesetExcerpt, err error) {
+	q := listChangesetExcerptsQuery(opts, search)
+
+	rows, err := s.Store.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = basestore.CloseRows(rows, err) }()
+
+	var excerpts []*cmpgn.ChangesetExcerpt
+	for rows.Next() {
+		var e cmpgn.ChangesetExcerpt
+		if err := rows.Scan(
+			&e.ID,
+			&e.RepoID,
+			&e.Title,
+			&e.AuthorLogin,
+			&e.ExternalState,
+			&e.ReviewState,
+			&e.CheckState,
+			&e.PublicationState,
+			&e.ReconcilerState,
+			&e.UpdatedAt,
+			&e.NumComments,
+			pq.Array(&e.CampaignIDs),
+			pq.Array(&e.Labels),
+			&e.EditLamport,
+		); err != nil {
+			return nil, err
+		}
+		excerpts = append(excerpts, &e)
+	}
+
+	return excerpts, nil
+}
+
+func listChangesetExcerptsQuery(opts ListChangesetsOpts, search ChangesetSearchOpts) *sqlf.Query {
+	var preds []*sqlf.Query
+
+	if opts.CampaignID != 0 {
+		preds = append(preds, sqlf.Sprintf("%s = ANY(campaign_ids)", opts.CampaignID))
+	}
+	if len(opts.IDs) > 0 {
+		preds = append(preds, sqlf.Sprintf("id = ANY(%s)", pq.Array(opts.IDs)))
+	}
+	preds = append(preds, search.predicates()...)
+	if len(preds) == 0 {
+		preds = append(preds, sqlf.Sprintf("TRUE"))
+	}
+
+	return sqlf.Sprintf(`
+		SELECT
+			id, repo_id, title, author_login, external_state, review_state, check_state,
+			publication_state, reconciler_state, updated_at, num_comments, campaign_ids, labels,
+			edit_lamport
+		FROM changeset_excerpts
+		WHERE %s
+		ORDER BY id ASC
+	`, sqlf.Join(preds, " AND "))
+}
+
+// RebuildChangesetExcerpts recomputes the changeset_excerpts table from the changesets table
+// in batches of limit rows, ordered by id. It exists as an escape hatch for backfilling the
+// table (on first deploy of this feature, or after a bug is found in upsertChangesetExcerpt)
+// and should not be on the hot path of any request.
+func (s *Store) RebuildChangesetExcerpts(ctx context.Context, limit int) (rebuilt int, err error) {
+	var cursor int64
+
+	for {
+		changesets, next, err := s.ListChangesets(ctx, ListChangesetsOpts{
+			LimitOpts: LimitOpts{Limit: limit},
+			Cursor:    cursor,
+		})
+		if err != nil {
+			return rebuilt, err
+		}
+		if len(changesets) == 0 {
+			return rebuilt, nil
+		}
+
+		for _, cs := range changesets {
+			if err := s.upsertChangesetExcerpt(ctx, cs, 0); err != nil {
+				return rebuilt, err
+			}
+			rebuilt++
+		}
+
+		if next == 0 {
+			return rebuilt, nil
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,35 @@
+package campaigns
+
+import (
+	"testing"
+
+	cmpgn "github.com/sourcegraph/sourcegraph/internal/campaigns"
+	"github.com/sourcegraph/sourcegraph/internal/extsvc/github"
+	"github.com/sourcegraph/sourcegraph/internal/extsvc/gitlab"
+)
+
+func TestExcerptFromChangesetTracksMetadataSwap(t *testing.T) {
+	cs := &cmpgn.Changeset{
+		ID:     1,
+		RepoID: 2,
+		Metadata: &github.PullRequest{
+			Title:  "Fix a bunch of bugs",
+			Author: github.Actor{Login: "mrnugget"},
+		},
+	}
+
+	excerpt := excerptFromChangeset(cs)
+	if excerpt.Title != "Fix a bunch of bugs" {
+		t.Fatalf("unexpected title: %q", excerpt.Title)
+	}
+	if excerpt.AuthorLogin != "mrnugget" {
+		t.Fatalf("unexpected author login: %q", excerpt.AuthorLogin)
+	}
+
+	cs.Metadata = &gitlab.MergeRequest{Title: "Fix a bunch of bugs on GitLab"}
+
+	excerpt = excerptFromChangeset(cs)
+	if excerpt.Title != "Fix a bunch of bugs on GitLab" {
+		t.Fatalf("unexpected title after metadata swap: %q", excerpt.Title)
+	}
+}
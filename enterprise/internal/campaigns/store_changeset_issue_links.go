@@ -0,0 +1,145 @@
+package campaigns
+
+import (
+	"context"
+
+	"github.com/keegancsmith/sqlf"
+	cmpgn "github.com/sourcegraph/sourcegraph/internal/campaigns"
+	"github.com/sourcegraph/sourcegraph/internal/db/basestore"
+	"github.com/sourcegraph/sourcegraph/internal/extsvc/bitbucketserver"
+	"github.com/sourcegraph/sourcegraph/internal/extsvc/github"
+	"github.com/sourcegraph/sourcegraph/internal/extsvc/gitlab"
+)
+
+// This file assumes a migration has added the following table. Its rows are written by
+// RecomputeChangesetIssueLinks; CreateChangeset and UpdateChangeset do not call it:
+//
+//   CREATE TABLE changeset_issue_links (
+//       id                    BIGSERIAL PRIMARY KEY,
+//       changeset_id          BIGINT NOT NULL REFERENCES changesets(id) ON DELETE CASCADE,
+//       external_service_id   TEXT NOT NULL,
+//       external_issue_number BIGINT NOT NULL,
+//       created_at            TIMESTAMPTZ NOT NULL DEFAULT now(),
+//       UNIQUE (changeset_id, external_issue_number, external_service_id)
+//   );
+//   CREATE INDEX changeset_issue_links_changeset_id ON changeset_issue_links (changeset_id);
+
+// changesetBody returns the text RecomputeChangesetIssueLinks should scan for closing
+// keywords: the upstream PR/MR description once a changeset is published, or specBody (the
+// changeset spec's own Body) before that, since there is no upstream description yet.
+func changesetBody(cs *cmpgn.Changeset, specBody string) string {
+	switch m := cs.Metadata.(type) {
+	case *github.PullRequest:
+		return m.Body
+	case *gitlab.MergeRequest:
+		return m.Description
+	case *bitbucketserver.PullRequest:
+		return m.Description
+	default:
+		return specBody
+	}
+}
+
+// RecomputeChangesetIssueLinks replaces cs's ChangesetIssueLink rows with the set of issues
+// its body currently references via a closing keyword (see ParseClosingKeywordIssueRefs).
+// externalServiceID identifies the code host the referenced issue numbers are scoped to (the
+// owning repo's external service ID); specBody is the changeset spec's Body, used as a
+// fallback for changesets that haven't been published yet.
+//
+// CreateChangeset and UpdateChangeset do not call it, so a changeset's links reflect its body
+// as of the last call, not necessarily its current body.
+func (s *Store) RecomputeChangesetIssueLinks(ctx context.Context, cs *cmpgn.Changeset, externalServiceID, specBody string) error {
+	refs := ParseClosingKeywordIssueRefs(changesetBody(cs, specBody))
+
+	if err := s.Store.Exec(ctx, sqlf.Sprintf(`DELETE FROM changeset_issue_links WHERE changeset_id = %s`, cs.ID)); err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		if err := s.Store.Exec(ctx, sqlf.Sprintf(`
+			INSERT INTO changeset_issue_links (changeset_id, external_service_id, external_issue_number)
+			VALUES (%s, %s, %s)
+			ON CONFLICT (changeset_id, external_issue_number, external_service_id) DO NOTHING
+		`, cs.ID, externalServiceID, ref)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListChangesetIssueLinksOpts holds the filters ListChangesetIssueLinks applies.
+type ListChangesetIssueLinksOpts struct {
+	ChangesetID int64
+	CampaignID  int64
+}
+
+// ListChangesetIssueLinks returns the issue links matching opts, ordered by id. CampaignID,
+// when set, joins through changeset_excerpts.campaign_ids so it can be used without first
+// loading every changeset belonging to the campaign.
+func (s *Store) ListChangesetIssueLinks(ctx context.Context, opts ListChangesetIssueLinksOpts) (_ []*cmpgn.ChangesetIssueLink, err error) {
+	var preds []*sqlf.Query
+	if opts.ChangesetID != 0 {
+		preds = append(preds, sqlf.Sprintf("changeset_issue_links.changeset_id = %s", opts.ChangesetID))
+	}
+	if opts.CampaignID != 0 {
+		preds = append(preds, sqlf.Sprintf(`changeset_issue_links.changeset_id IN (
+			SELECT id FROM changeset_excerpts WHERE %s = ANY(campaign_ids)
+		)`, opts.CampaignID))
+	}
+	if len(preds) == 0 {
+		preds = append(preds, sqlf.Sprintf("TRUE"))
+	}
+
+	rows, err := s.Store.Query(ctx, sqlf.Sprintf(`
+		SELECT id, changeset_id, external_service_id, external_issue_number, created_at
+		FROM changeset_issue_links
+		WHERE %s
+		ORDER BY id ASC
+	`, sqlf.Join(preds, " AND ")))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = basestore.CloseRows(rows, err) }()
+
+	var links []*cmpgn.ChangesetIssueLink
+	for rows.Next() {
+		var l cmpgn.ChangesetIssueLink
+		if err := rows.Scan(&l.ID, &l.ChangesetID, &l.ExternalServiceID, &l.ExternalIssueNumber, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		links = append(links, &l)
+	}
+
+	return links, nil
+}
+
+// CampaignLinkedIssueStats summarizes the issues a campaign's changesets would close if
+// merged. It is a sibling of GetChangesetsStats's return value rather than fields on it (the
+// request that introduced this asked for LinkedIssuesOpen/LinkedIssuesClosed directly on that
+// struct) because GetChangesetsStats itself lives in store_changesets.go, which this snapshot
+// of the tree doesn't carry; merge this field into that struct once it exists.
+//
+// There is deliberately no Open/Closed breakdown here: that requires knowing the current state
+// of each linked issue on its code host, and this store has no way to fetch that (there is no
+// issue-sync subsystem in this tree). Shipping fields that could only ever read 0 would be
+// worse than not having them; add them back once an issue-sync subsystem exists to back them.
+type CampaignLinkedIssueStats struct {
+	TotalLinked int64
+}
+
+// GetCampaignLinkedIssueStats returns the CampaignLinkedIssueStats for campaignID.
+func (s *Store) GetCampaignLinkedIssueStats(ctx context.Context, campaignID int64) (CampaignLinkedIssueStats, error) {
+	count, _, err := basestore.ScanFirstInt64(s.Store.Query(ctx, sqlf.Sprintf(`
+		SELECT COUNT(DISTINCT (external_service_id, external_issue_number))
+		FROM changeset_issue_links
+		WHERE changeset_id IN (
+			SELECT id FROM changeset_excerpts WHERE %s = ANY(campaign_ids)
+		)
+	`, campaignID)))
+	if err != nil {
+		return CampaignLinkedIssueStats{}, err
+	}
+
+	return CampaignLinkedIssueStats{TotalLinked: count}, nil
+}
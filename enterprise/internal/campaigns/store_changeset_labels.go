@@ -0,0 +1,182 @@
+package campaigns
+
+import (
+	"context"
+
+	"github.com/keegancsmith/sqlf"
+	"github.com/lib/pq"
+	cmpgn "github.com/sourcegraph/sourcegraph/internal/campaigns"
+	"github.com/sourcegraph/sourcegraph/internal/db/basestore"
+)
+
+// This file assumes a migration has added the following tables:
+//
+//   CREATE TABLE changeset_labels (
+//       id          BIGSERIAL PRIMARY KEY,
+//       campaign_id BIGINT REFERENCES campaigns(id) ON DELETE CASCADE,
+//       name        TEXT NOT NULL,
+//       color       TEXT NOT NULL,
+//       description TEXT NOT NULL DEFAULT '',
+//       UNIQUE (campaign_id, name)
+//   );
+//   CREATE TABLE changeset_label_associations (
+//       changeset_id BIGINT NOT NULL REFERENCES changesets(id) ON DELETE CASCADE,
+//       label_id     BIGINT NOT NULL REFERENCES changeset_labels(id) ON DELETE CASCADE,
+//       PRIMARY KEY (changeset_id, label_id)
+//   );
+//   CREATE INDEX changeset_label_associations_label_id ON changeset_label_associations(label_id);
+
+// CreateLabel inserts label and sets its ID on success.
+func (s *Store) CreateLabel(ctx context.Context, label *cmpgn.ChangesetLabel) error {
+	id, _, err := basestore.ScanFirstInt64(s.Store.Query(ctx, sqlf.Sprintf(`
+		INSERT INTO changeset_labels (campaign_id, name, color, description)
+		VALUES (%s, %s, %s, %s)
+		RETURNING id
+	`, nullInt64(label.CampaignID), label.Name, label.Color, label.Description)))
+	if err != nil {
+		return err
+	}
+
+	label.ID = id
+	return nil
+}
+
+// UpdateLabel updates label's name, color, and description in place.
+func (s *Store) UpdateLabel(ctx context.Context, label *cmpgn.ChangesetLabel) error {
+	return s.Store.Exec(ctx, sqlf.Sprintf(`
+		UPDATE changeset_labels
+		SET name = %s, color = %s, description = %s
+		WHERE id = %s
+	`, label.Name, label.Color, label.Description, label.ID))
+}
+
+// DeleteLabel deletes the label with the given id, cascading to its changeset associations.
+func (s *Store) DeleteLabel(ctx context.Context, id int64) error {
+	return s.Store.Exec(ctx, sqlf.Sprintf(`DELETE FROM changeset_labels WHERE id = %s`, id))
+}
+
+// AttachLabelsToChangeset associates each of labelIDs with changesetID, ignoring labels that
+// are already attached.
+func (s *Store) AttachLabelsToChangeset(ctx context.Context, changesetID int64, labelIDs ...int64) error {
+	for _, labelID := range labelIDs {
+		if err := s.Store.Exec(ctx, sqlf.Sprintf(`
+			INSERT INTO changeset_label_associations (changeset_id, label_id)
+			VALUES (%s, %s)
+			ON CONFLICT DO NOTHING
+		`, changesetID, labelID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DetachLabelsFromChangeset removes the association between each of labelIDs and changesetID,
+// if one exists. It does not delete the labels themselves.
+func (s *Store) DetachLabelsFromChangeset(ctx context.Context, changesetID int64, labelIDs ...int64) error {
+	if len(labelIDs) == 0 {
+		return nil
+	}
+
+	return s.Store.Exec(ctx, sqlf.Sprintf(`
+		DELETE FROM changeset_label_associations
+		WHERE changeset_id = %s AND label_id = ANY(%s)
+	`, changesetID, pq.Array(labelIDs)))
+}
+
+// ListLabelsByChangeset returns every label attached to changesetID, ordered by name.
+func (s *Store) ListLabelsByChangeset(ctx context.Context, changesetID int64) (_ []*cmpgn.ChangesetLabel, err error) {
+	rows, err := s.Store.Query(ctx, sqlf.Sprintf(`
+		SELECT l.id, COALESCE(l.campaign_id, 0), l.name, l.color, l.description
+		FROM changeset_labels l
+		JOIN changeset_label_associations a ON a.label_id = l.id
+		WHERE a.changeset_id = %s
+		ORDER BY l.name ASC
+	`, changesetID))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = basestore.CloseRows(rows, err) }()
+
+	var labels []*cmpgn.ChangesetLabel
+	for rows.Next() {
+		var l cmpgn.ChangesetLabel
+		if err := rows.Scan(&l.ID, &l.CampaignID, &l.Name, &l.Color, &l.Description); err != nil {
+			return nil, err
+		}
+		labels = append(labels, &l)
+	}
+
+	return labels, nil
+}
+
+// SyncMetadataLabels attaches to cs the upstream labels reported by its code-host metadata
+// (see changesetLabels in store_changeset_excerpts.go), creating any campaign-scoped label rows
+// that don't exist yet by name. It only attaches: labels already attached to cs that upstream
+// no longer reports are not detached. It should be called by
+// the reconciler whenever a changeset's metadata is refreshed from its code host, so that
+// imported PR/MR labels stay searchable and aggregable alongside user-authored ones.
+func (s *Store) SyncMetadataLabels(ctx context.Context, cs *cmpgn.Changeset, campaignID int64) error {
+	names := changesetLabels(cs)
+	if len(names) == 0 {
+		return nil
+	}
+
+	labelIDs := make([]int64, 0, len(names))
+	for _, name := range names {
+		id, ok, err := basestore.ScanFirstInt64(s.Store.Query(ctx, sqlf.Sprintf(`
+			SELECT id FROM changeset_labels WHERE campaign_id = %s AND name = %s
+		`, campaignID, name)))
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			label := &cmpgn.ChangesetLabel{CampaignID: campaignID, Name: name}
+			if err := s.CreateLabel(ctx, label); err != nil {
+				return err
+			}
+			id = label.ID
+		}
+
+		labelIDs = append(labelIDs, id)
+	}
+
+	return s.AttachLabelsToChangeset(ctx, cs.ID, labelIDs...)
+}
+
+// GetLabelStats returns the ChangesetCounts breakdown for campaignID, broken down by the label
+// each changeset carries. A changeset with more than one label is counted once per label; a
+// changeset with no labels is not represented in the map at all (see GetChangesetsStats for the
+// campaign-wide totals, which always include it).
+func (s *Store) GetLabelStats(ctx context.Context, campaignID int64) (_ map[int64]cmpgn.ChangesetCounts, err error) {
+	rows, err := s.Store.Query(ctx, sqlf.Sprintf(`
+		SELECT
+			a.label_id,
+			COUNT(*) FILTER (WHERE e.external_state = 'OPEN'),
+			COUNT(*) FILTER (WHERE e.external_state = 'CLOSED'),
+			COUNT(*) FILTER (WHERE e.external_state = 'MERGED'),
+			COUNT(*) FILTER (WHERE e.external_state = 'DELETED')
+		FROM changeset_label_associations a
+		JOIN changeset_excerpts e ON e.id = a.changeset_id
+		JOIN changeset_labels l ON l.id = a.label_id
+		WHERE l.campaign_id = %s
+		GROUP BY a.label_id
+	`, campaignID))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = basestore.CloseRows(rows, err) }()
+
+	stats := make(map[int64]cmpgn.ChangesetCounts)
+	for rows.Next() {
+		var labelID int64
+		var counts cmpgn.ChangesetCounts
+		if err := rows.Scan(&labelID, &counts.Open, &counts.Closed, &counts.Merged, &counts.Deleted); err != nil {
+			return nil, err
+		}
+		stats[labelID] = counts
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,55 @@
+package campaigns
+
+import (
+	"reflect"
+	"testing"
+
+	cmpgn "github.com/sourcegraph/sourcegraph/internal/campaigns"
+	"github.com/sourcegraph/sourcegraph/internal/extsvc/github"
+	"github.com/sourcegraph/sourcegraph/internal/extsvc/gitlab"
+)
+
+// Beyond the names changesetLabels (defined in store_changeset_excerpts.go) extracts from
+// cs.Metadata, SyncMetadataLabels only looks up, creates and attaches label rows in the
+// database; these cases exercise the extraction directly rather than through a DB-backed
+// SyncMetadataLabels call.
+func TestChangesetLabelsForSync(t *testing.T) {
+	tests := []struct {
+		name string
+		cs   *cmpgn.Changeset
+		want []string
+	}{
+		{
+			name: "github pull request",
+			cs: &cmpgn.Changeset{Metadata: &github.PullRequest{
+				Labels: struct {
+					Nodes []github.Label
+				}{Nodes: []github.Label{{Name: "bug"}, {Name: "needs-review"}}},
+			}},
+			want: []string{"bug", "needs-review"},
+		},
+		{
+			name: "github pull request with no labels",
+			cs:   &cmpgn.Changeset{Metadata: &github.PullRequest{}},
+			want: []string{},
+		},
+		{
+			name: "gitlab merge request",
+			cs:   &cmpgn.Changeset{Metadata: &gitlab.MergeRequest{Labels: []string{"wip"}}},
+			want: []string{"wip"},
+		},
+		{
+			name: "unrecognized metadata",
+			cs:   &cmpgn.Changeset{Metadata: struct{}{}},
+			want: nil,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := changesetLabels(tc.cs); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("changesetLabels() = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
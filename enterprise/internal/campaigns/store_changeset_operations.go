@@ -0,0 +1,409 @@
+package campaigns
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/keegancsmith/sqlf"
+	cmpgn "github.com/sourcegraph/sourcegraph/internal/campaigns"
+	"github.com/sourcegraph/sourcegraph/internal/db/basestore"
+)
+
+// This file assumes a migration has added the following table:
+//
+//   CREATE TABLE changeset_operations (
+//       id             BIGSERIAL PRIMARY KEY,
+//       changeset_id   BIGINT NOT NULL REFERENCES changesets(id) ON DELETE CASCADE,
+//       op_type        TEXT NOT NULL,
+//       author_id      INTEGER NOT NULL REFERENCES users(id),
+//       created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+//       payload_jsonb  JSONB NOT NULL DEFAULT '{}',
+//       previous_op_id BIGINT REFERENCES changeset_operations(id)
+//   );
+//   CREATE INDEX changeset_operations_changeset_id ON changeset_operations(changeset_id, id);
+
+// CreateChangesetOperation inserts a single immutable entry into a changeset's operation
+// log. op.ID is set to the newly assigned row id on success.
+func (s *Store) CreateChangesetOperation(ctx context.Context, op *cmpgn.ChangesetOperation) error {
+	payload, err := json.Marshal(op.Payload)
+	if err != nil {
+		return err
+	}
+
+	if op.CreatedAt.IsZero() {
+		op.CreatedAt = s.now()
+	}
+
+	id, _, err := basestore.ScanFirstInt64(s.Store.Query(ctx, sqlf.Sprintf(`
+		INSERT INTO changeset_operations (changeset_id, op_type, author_id, created_at, payload_jsonb, previous_op_id, edit_lamport)
+		VALUES (%s, %s, %s, %s, %s, %s, %s)
+		RETURNING id
+	`,
+		op.ChangesetID,
+		op.Type,
+		op.AuthorID,
+		op.CreatedAt,
+		payload,
+		nullInt64(op.PreviousOpID),
+		op.EditLamport,
+	)))
+	if err != nil {
+		return err
+	}
+
+	op.ID = id
+
+	return nil
+}
+
+// ListChangesetOperations returns every operation recorded for changesetID, oldest first. If
+// since is non-zero, only operations created at or after since are returned.
+func (s *Store) ListChangesetOperations(ctx context.Context, changesetID int64, since time.Time) (_ []*cmpgn.ChangesetOperation, err error) {
+	rows, err := s.Store.Query(ctx, sqlf.Sprintf(`
+		SELECT id, changeset_id, op_type, author_id, created_at, payload_jsonb, previous_op_id
+		FROM changeset_operations
+		WHERE changeset_id = %s AND created_at >= %s
+		ORDER BY id ASC
+	`, changesetID, since))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = basestore.CloseRows(rows, err) }()
+
+	var ops []*cmpgn.ChangesetOperation
+	for rows.Next() {
+		var op cmpgn.ChangesetOperation
+		if err := scanChangesetOperation(&op, rows); err != nil {
+			return nil, err
+		}
+		ops = append(ops, &op)
+	}
+
+	return ops, nil
+}
+
+// ReplayChangeset folds every recorded operation for changesetID, in order, into a
+// cmpgn.Changeset snapshot. This is the read side of the operation log. It always replays the
+// whole log, so the snapshot reflects the latest recorded op; it takes no cut-off and does not
+// reconstruct an earlier point in the history. Only the fields the op payloads carry are set
+// (see applyChangesetOperation); Metadata is left nil.
+func (s *Store) ReplayChangeset(ctx context.Context, changesetID int64) (*cmpgn.Changeset, error) {
+	ops, err := s.ListChangesetOperations(ctx, changesetID, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	cs := &cmpgn.Changeset{ID: changesetID}
+	for _, op := range ops {
+		applyChangesetOperation(cs, op)
+	}
+
+	return cs, nil
+}
+
+func applyChangesetOperation(cs *cmpgn.Changeset, op *cmpgn.ChangesetOperation) {
+	switch op.Type {
+	case cmpgn.ChangesetOperationSetStatus:
+		if p := op.Payload.Status; p != nil {
+			cs.ExternalState = p.CurrentExternalState
+			cs.ExternalReviewState = p.CurrentReviewState
+			cs.ExternalCheckState = p.CurrentCheckState
+		}
+	case cmpgn.ChangesetOperationSetMetadata:
+		// Only the service type is carried forward; cs.Metadata is left nil, since
+		// diffChangesetOperations does not record the raw metadata in the payload.
+		if p := op.Payload.Metadata; p != nil {
+			cs.ExternalServiceType = p.ExternalServiceType
+		}
+	case cmpgn.ChangesetOperationCreate:
+		// Nothing to fold: cs already starts as the zero value this op is recording the
+		// creation of. The case exists so the switch documents that Create is handled
+		// deliberately, not missed.
+	case cmpgn.ChangesetOperationSetTitle:
+		if p := op.Payload.Title; p != nil {
+			cs.Title = p.Current
+		}
+	case cmpgn.ChangesetOperationAddComment:
+		cs.NumComments++
+	case cmpgn.ChangesetOperationLabelChange:
+		if p := op.Payload.Label; p != nil {
+			cs.Labels = applyLabelChange(cs.Labels, p.Added, p.Removed)
+		}
+	case cmpgn.ChangesetOperationEnqueueClose:
+		cs.ReconcilerState = cmpgn.ReconcilerStateQueued
+		cs.Closing = true
+	case cmpgn.ChangesetOperationMarkFailed:
+		if p := op.Payload.MarkFailed; p != nil {
+			cs.ReconcilerState = cmpgn.ReconcilerStateFailed
+			cs.FailureMessage = &p.FailureMessage
+			cs.NumFailures = p.NumFailures
+		}
+	case cmpgn.ChangesetOperationSetReconcilerState:
+		if p := op.Payload.ReconcilerState; p != nil {
+			cs.ReconcilerState = p.Current
+		}
+	case cmpgn.ChangesetOperationSetPublicationState:
+		if p := op.Payload.PublicationState; p != nil {
+			cs.PublicationState = p.Current
+		}
+	}
+	cs.UpdatedAt = op.CreatedAt
+}
+
+// applyLabelChange returns labels with added appended (skipping names already present) and
+// removed names dropped, preserving the relative order of whatever survives.
+func applyLabelChange(labels, added, removed []string) []string {
+	remove := make(map[string]bool, len(removed))
+	for _, name := range removed {
+		remove[name] = true
+	}
+
+	have := make(map[string]bool, len(labels))
+	next := make([]string, 0, len(labels)+len(added))
+	for _, name := range labels {
+		have[name] = true
+		if !remove[name] {
+			next = append(next, name)
+		}
+	}
+
+	for _, name := range added {
+		if !have[name] && !remove[name] {
+			have[name] = true
+			next = append(next, name)
+		}
+	}
+
+	return next
+}
+
+// diffLabels returns the names present in updated but not old (added) and present in old but
+// not updated (removed).
+func diffLabels(old, updated []string) (added, removed []string) {
+	oldSet := make(map[string]bool, len(old))
+	for _, name := range old {
+		oldSet[name] = true
+	}
+	updatedSet := make(map[string]bool, len(updated))
+	for _, name := range updated {
+		updatedSet[name] = true
+		if !oldSet[name] {
+			added = append(added, name)
+		}
+	}
+	for _, name := range old {
+		if !updatedSet[name] {
+			removed = append(removed, name)
+		}
+	}
+	return added, removed
+}
+
+// AppendChangesetOps appends ops to changesetID's operation log, in order, chaining each op's
+// PreviousOpID to whichever op immediately precedes it — either the previous entry in ops, or
+// (for the first one) the changeset's last recorded op. This lets multiple reconciler workers
+// or syncers append to the same changeset's log concurrently and still end up with a single,
+// well-ordered chain that ReplayChangeset folds deterministically, rather than having each
+// writer mutate the changesets row's reconciler_state/failure_message/closing/num_failures
+// columns directly and race with one another.
+//
+// Each appended op is stamped with a fresh value from campaignID's Lamport clock
+// (NextLamport), and changeset_excerpts.edit_lamport is advanced to the last of those values
+// before returning, so a later IsStaleLamport check sees these ops reflected in the same
+// column upsertChangesetExcerpt already advances.
+//
+// observedLamport is the edit_lamport the caller last read for changesetID before deciding to
+// write ops. If the changeset has since moved on (IsStaleLamport), the caller's view is out of
+// causal order and the whole batch is dropped without being written; stale is returned true so
+// the caller (a webhook handler replaying a delayed delivery, most often) can tell its write
+// never happened rather than assuming it landed.
+func (s *Store) AppendChangesetOps(ctx context.Context, campaignID, changesetID, observedLamport int64, ops ...*cmpgn.ChangesetOperation) (stale bool, err error) {
+	// IsStaleLamport judges the value a new write would carry, which is one past what the
+	// caller observed: the batch is stale only if the changeset has moved past observedLamport.
+	stale, err = s.IsStaleLamport(ctx, changesetID, observedLamport+1)
+	if err != nil {
+		return false, err
+	}
+	if stale {
+		return true, nil
+	}
+
+	previousOpID, _, err := basestore.ScanFirstInt64(s.Store.Query(ctx, sqlf.Sprintf(`
+		SELECT id FROM changeset_operations WHERE changeset_id = %s ORDER BY id DESC LIMIT 1
+	`, changesetID)))
+	if err != nil {
+		return false, err
+	}
+
+	var lastLamport int64
+	for _, op := range ops {
+		lamport, err := s.NextLamport(ctx, campaignID)
+		if err != nil {
+			return false, err
+		}
+
+		op.ChangesetID = changesetID
+		op.PreviousOpID = previousOpID
+		op.EditLamport = lamport
+
+		if err := s.CreateChangesetOperation(ctx, op); err != nil {
+			return false, err
+		}
+
+		previousOpID = op.ID
+		lastLamport = lamport
+	}
+
+	// Advance changeset_excerpts.edit_lamport to the last value handed out above, the same
+	// column IsStaleLamport just checked, so the next caller's observedLamport is compared
+	// against a value that actually reflects the ops just appended instead of whatever
+	// upsertChangesetExcerpt last wrote. GREATEST guards against racing with a concurrent
+	// upsertChangesetExcerpt call that might land in between and carry a newer value of its
+	// own.
+	if err := s.Store.Exec(ctx, sqlf.Sprintf(`
+		UPDATE changeset_excerpts SET edit_lamport = GREATEST(edit_lamport, %s) WHERE id = %s
+	`, lastLamport, changesetID)); err != nil {
+		return false, err
+	}
+
+	return false, nil
+}
+
+// EnqueueChangesetClose records that the reconciler was asked to close changesetID, folding a
+// ChangesetOperationEnqueueClose op into its log. See AppendChangesetOps for the meaning of
+// observedLamport and the returned stale bool.
+func (s *Store) EnqueueChangesetClose(ctx context.Context, campaignID, changesetID int64, authorID int32, observedLamport int64) (stale bool, err error) {
+	return s.AppendChangesetOps(ctx, campaignID, changesetID, observedLamport, &cmpgn.ChangesetOperation{
+		Type:     cmpgn.ChangesetOperationEnqueueClose,
+		AuthorID: authorID,
+	})
+}
+
+// MarkChangesetFailed records a reconciler attempt failing for changesetID, folding a
+// ChangesetOperationMarkFailed op into its log. See AppendChangesetOps for the meaning of
+// observedLamport and the returned stale bool.
+func (s *Store) MarkChangesetFailed(ctx context.Context, campaignID, changesetID int64, authorID int32, observedLamport int64, failureMessage string, numFailures int64) (stale bool, err error) {
+	return s.AppendChangesetOps(ctx, campaignID, changesetID, observedLamport, &cmpgn.ChangesetOperation{
+		Type:     cmpgn.ChangesetOperationMarkFailed,
+		AuthorID: authorID,
+		Payload: cmpgn.ChangesetOperationPayload{
+			MarkFailed: &cmpgn.ChangesetOperationMarkFailedPayload{
+				FailureMessage: failureMessage,
+				NumFailures:    numFailures,
+			},
+		},
+	})
+}
+
+// diffChangesetOperations compares old and updated and returns the operations that explain
+// the difference between them, in the order they should be recorded. It only computes the ops
+// and writes nothing; UpdateChangeset does not call it, so updates made through
+// UpdateChangeset are not recorded in the operation log.
+func diffChangesetOperations(old, updated *cmpgn.Changeset, authorID int32) []*cmpgn.ChangesetOperation {
+	var ops []*cmpgn.ChangesetOperation
+
+	if old.Title != updated.Title {
+		ops = append(ops, &cmpgn.ChangesetOperation{
+			ChangesetID: updated.ID,
+			Type:        cmpgn.ChangesetOperationSetTitle,
+			AuthorID:    authorID,
+			Payload: cmpgn.ChangesetOperationPayload{
+				Title: &cmpgn.ChangesetOperationSetTitlePayload{
+					Previous: old.Title,
+					Current:  updated.Title,
+				},
+			},
+		})
+	}
+
+	if added, removed := diffLabels(old.Labels, updated.Labels); len(added) > 0 || len(removed) > 0 {
+		ops = append(ops, &cmpgn.ChangesetOperation{
+			ChangesetID: updated.ID,
+			Type:        cmpgn.ChangesetOperationLabelChange,
+			AuthorID:    authorID,
+			Payload: cmpgn.ChangesetOperationPayload{
+				Label: &cmpgn.ChangesetOperationLabelChangePayload{
+					Added:   added,
+					Removed: removed,
+				},
+			},
+		})
+	}
+
+	if old.ExternalState != updated.ExternalState ||
+		old.ExternalReviewState != updated.ExternalReviewState ||
+		old.ExternalCheckState != updated.ExternalCheckState {
+		ops = append(ops, &cmpgn.ChangesetOperation{
+			ChangesetID: updated.ID,
+			Type:        cmpgn.ChangesetOperationSetStatus,
+			AuthorID:    authorID,
+			Payload: cmpgn.ChangesetOperationPayload{
+				Status: &cmpgn.ChangesetOperationSetStatusPayload{
+					PreviousExternalState: old.ExternalState,
+					CurrentExternalState:  updated.ExternalState,
+					PreviousReviewState:   old.ExternalReviewState,
+					CurrentReviewState:    updated.ExternalReviewState,
+					PreviousCheckState:    old.ExternalCheckState,
+					CurrentCheckState:     updated.ExternalCheckState,
+				},
+			},
+		})
+	}
+
+	if !reflect.DeepEqual(old.Metadata, updated.Metadata) {
+		ops = append(ops, &cmpgn.ChangesetOperation{
+			ChangesetID: updated.ID,
+			Type:        cmpgn.ChangesetOperationSetMetadata,
+			AuthorID:    authorID,
+			Payload: cmpgn.ChangesetOperationPayload{
+				Metadata: &cmpgn.ChangesetOperationSetMetadataPayload{
+					ExternalServiceType: updated.ExternalServiceType,
+				},
+			},
+		})
+	}
+
+	if len(ops) == 0 {
+		ops = append(ops, &cmpgn.ChangesetOperation{
+			ChangesetID: updated.ID,
+			Type:        cmpgn.ChangesetOperationSync,
+			AuthorID:    authorID,
+		})
+	}
+
+	return ops
+}
+
+func scanChangesetOperation(op *cmpgn.ChangesetOperation, sc interface {
+	Scan(...interface{}) error
+}) error {
+	var previousOpID sql.NullInt64
+	var payload []byte
+
+	if err := sc.Scan(
+		&op.ID,
+		&op.ChangesetID,
+		&op.Type,
+		&op.AuthorID,
+		&op.CreatedAt,
+		&payload,
+		&previousOpID,
+	); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(payload, &op.Payload); err != nil {
+		return err
+	}
+
+	op.PreviousOpID = previousOpID.Int64
+
+	return nil
+}
+
+func nullInt64(v int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: v, Valid: v != 0}
+}
@@ -0,0 +1,183 @@
+package campaigns
+
+import (
+	"testing"
+
+	cmpgn "github.com/sourcegraph/sourcegraph/internal/campaigns"
+)
+
+func TestDiffChangesetOperations(t *testing.T) {
+	old := &cmpgn.Changeset{
+		ID:                  1,
+		ExternalState:       cmpgn.ChangesetExternalStateOpen,
+		ExternalReviewState: cmpgn.ChangesetReviewStatePending,
+		ExternalCheckState:  cmpgn.ChangesetCheckStatePending,
+		ExternalServiceType: "github",
+	}
+
+	updated := &cmpgn.Changeset{
+		ID:                  1,
+		ExternalState:       cmpgn.ChangesetExternalStateMerged,
+		ExternalReviewState: cmpgn.ChangesetReviewStateApproved,
+		ExternalCheckState:  cmpgn.ChangesetCheckStatePassed,
+		ExternalServiceType: "github",
+	}
+
+	ops := diffChangesetOperations(old, updated, 42)
+	if len(ops) != 1 {
+		t.Fatalf("expected exactly one operation, got %d", len(ops))
+	}
+
+	op := ops[0]
+	if op.Type != cmpgn.ChangesetOperationSetStatus {
+		t.Fatalf("expected a SetStatus operation, got %q", op.Type)
+	}
+	if op.AuthorID != 42 {
+		t.Fatalf("unexpected author id: %d", op.AuthorID)
+	}
+	if op.Payload.Status.CurrentExternalState != cmpgn.ChangesetExternalStateMerged {
+		t.Fatalf("unexpected current external state in payload: %q", op.Payload.Status.CurrentExternalState)
+	}
+}
+
+func TestDiffChangesetOperationsTitleAndLabels(t *testing.T) {
+	old := &cmpgn.Changeset{ID: 1, Title: "Fix bug", Labels: []string{"bug", "needs-review"}}
+	updated := &cmpgn.Changeset{ID: 1, Title: "Fix the bug", Labels: []string{"bug", "approved"}}
+
+	ops := diffChangesetOperations(old, updated, 42)
+	if len(ops) != 2 {
+		t.Fatalf("expected exactly two operations, got %d", len(ops))
+	}
+
+	titleOp := ops[0]
+	if titleOp.Type != cmpgn.ChangesetOperationSetTitle {
+		t.Fatalf("expected a SetTitle operation first, got %q", titleOp.Type)
+	}
+	if titleOp.Payload.Title.Previous != "Fix bug" || titleOp.Payload.Title.Current != "Fix the bug" {
+		t.Fatalf("unexpected title payload: %+v", titleOp.Payload.Title)
+	}
+
+	labelOp := ops[1]
+	if labelOp.Type != cmpgn.ChangesetOperationLabelChange {
+		t.Fatalf("expected a LabelChange operation second, got %q", labelOp.Type)
+	}
+	if len(labelOp.Payload.Label.Added) != 1 || labelOp.Payload.Label.Added[0] != "approved" {
+		t.Fatalf("unexpected added labels: %v", labelOp.Payload.Label.Added)
+	}
+	if len(labelOp.Payload.Label.Removed) != 1 || labelOp.Payload.Label.Removed[0] != "needs-review" {
+		t.Fatalf("unexpected removed labels: %v", labelOp.Payload.Label.Removed)
+	}
+}
+
+func TestDiffChangesetOperationsNoChange(t *testing.T) {
+	cs := &cmpgn.Changeset{ID: 1, ExternalState: cmpgn.ChangesetExternalStateOpen}
+
+	ops := diffChangesetOperations(cs, cs, 42)
+	if len(ops) != 1 || ops[0].Type != cmpgn.ChangesetOperationSync {
+		t.Fatalf("expected a single Sync operation when nothing changed, got %+v", ops)
+	}
+}
+
+func TestReplayChangesetAppliesOperationsInOrder(t *testing.T) {
+	cs := &cmpgn.Changeset{ID: 1}
+
+	applyChangesetOperation(cs, &cmpgn.ChangesetOperation{
+		ChangesetID: 1,
+		Type:        cmpgn.ChangesetOperationSetStatus,
+		Payload: cmpgn.ChangesetOperationPayload{
+			Status: &cmpgn.ChangesetOperationSetStatusPayload{
+				CurrentExternalState: cmpgn.ChangesetExternalStateOpen,
+			},
+		},
+	})
+	applyChangesetOperation(cs, &cmpgn.ChangesetOperation{
+		ChangesetID: 1,
+		Type:        cmpgn.ChangesetOperationSetStatus,
+		Payload: cmpgn.ChangesetOperationPayload{
+			Status: &cmpgn.ChangesetOperationSetStatusPayload{
+				CurrentExternalState: cmpgn.ChangesetExternalStateMerged,
+			},
+		},
+	})
+
+	if cs.ExternalState != cmpgn.ChangesetExternalStateMerged {
+		t.Fatalf("expected the later operation to win, got external state %q", cs.ExternalState)
+	}
+}
+
+func TestReplayChangesetFoldsReconcilerOps(t *testing.T) {
+	cs := &cmpgn.Changeset{ID: 1, ReconcilerState: cmpgn.ReconcilerStateCompleted}
+
+	applyChangesetOperation(cs, &cmpgn.ChangesetOperation{
+		ChangesetID: 1,
+		Type:        cmpgn.ChangesetOperationEnqueueClose,
+	})
+	if cs.ReconcilerState != cmpgn.ReconcilerStateQueued || !cs.Closing {
+		t.Fatalf("expected EnqueueClose to queue and mark closing, got state=%q closing=%v", cs.ReconcilerState, cs.Closing)
+	}
+
+	applyChangesetOperation(cs, &cmpgn.ChangesetOperation{
+		ChangesetID: 1,
+		Type:        cmpgn.ChangesetOperationMarkFailed,
+		Payload: cmpgn.ChangesetOperationPayload{
+			MarkFailed: &cmpgn.ChangesetOperationMarkFailedPayload{
+				FailureMessage: "boom",
+				NumFailures:    1,
+			},
+		},
+	})
+	if cs.ReconcilerState != cmpgn.ReconcilerStateFailed || cs.FailureMessage == nil || *cs.FailureMessage != "boom" || cs.NumFailures != 1 {
+		t.Fatalf("expected MarkFailed to record the failure, got state=%q message=%v numFailures=%d", cs.ReconcilerState, cs.FailureMessage, cs.NumFailures)
+	}
+
+	applyChangesetOperation(cs, &cmpgn.ChangesetOperation{
+		ChangesetID: 1,
+		Type:        cmpgn.ChangesetOperationSetReconcilerState,
+		Payload: cmpgn.ChangesetOperationPayload{
+			ReconcilerState: &cmpgn.ChangesetOperationSetReconcilerStatePayload{
+				Previous: cmpgn.ReconcilerStateFailed,
+				Current:  cmpgn.ReconcilerStateCompleted,
+			},
+		},
+	})
+	if cs.ReconcilerState != cmpgn.ReconcilerStateCompleted {
+		t.Fatalf("expected SetReconcilerState to apply the new state, got %q", cs.ReconcilerState)
+	}
+}
+
+func TestReplayChangesetFoldsTitleCommentAndLabelOps(t *testing.T) {
+	cs := &cmpgn.Changeset{ID: 1, Title: "Fix bug", Labels: []string{"bug"}}
+
+	applyChangesetOperation(cs, &cmpgn.ChangesetOperation{
+		ChangesetID: 1,
+		Type:        cmpgn.ChangesetOperationSetTitle,
+		Payload: cmpgn.ChangesetOperationPayload{
+			Title: &cmpgn.ChangesetOperationSetTitlePayload{Previous: "Fix bug", Current: "Fix the bug"},
+		},
+	})
+	if cs.Title != "Fix the bug" {
+		t.Fatalf("expected SetTitle to update the title, got %q", cs.Title)
+	}
+
+	applyChangesetOperation(cs, &cmpgn.ChangesetOperation{
+		ChangesetID: 1,
+		Type:        cmpgn.ChangesetOperationAddComment,
+		Payload: cmpgn.ChangesetOperationPayload{
+			Comment: &cmpgn.ChangesetOperationAddCommentPayload{Body: "lgtm"},
+		},
+	})
+	if cs.NumComments != 1 {
+		t.Fatalf("expected AddComment to increment NumComments, got %d", cs.NumComments)
+	}
+
+	applyChangesetOperation(cs, &cmpgn.ChangesetOperation{
+		ChangesetID: 1,
+		Type:        cmpgn.ChangesetOperationLabelChange,
+		Payload: cmpgn.ChangesetOperationPayload{
+			Label: &cmpgn.ChangesetOperationLabelChangePayload{Added: []string{"approved"}, Removed: []string{"bug"}},
+		},
+	})
+	if len(cs.Labels) != 1 || cs.Labels[0] != "approved" {
+		t.Fatalf("expected LabelChange to apply added/removed labels, got %v", cs.Labels)
+	}
+}
@@ -0,0 +1,88 @@
+package campaigns
+
+import (
+	"github.com/keegancsmith/sqlf"
+	"github.com/lib/pq"
+)
+
+// ChangesetSearchOpts holds the full-text and label filters a changeset listing can apply on
+// top of ListChangesetsOpts's state filters: TextSearch (e.g. a title-scoped "bugfix" term), Labels
+// (["security"]), and LabelsMatchAll (AND vs. OR semantics across Labels). This lets the
+// campaigns UI do issue-tracker-style search ("bugfix in:title label:security state:open")
+// without pulling every row into the app and scanning JSON blobs.
+//
+// These fields would naturally live directly on ListChangesetsOpts and CountChangesetsOpts
+// (the request that introduced this type asked for exactly that), but both of those are
+// defined in store_changesets.go, which this snapshot of the tree doesn't carry. Keeping the
+// fields here as a sibling struct, passed to ListChangesetExcerpts (its only consumer) alongside
+// a ListChangesetsOpts, means the two can
+// be merged into one the moment that file exists in this tree without changing this package's
+// public API again.
+//
+// TextSearch reuses ListChangesetsTextSearchExpr (see store_changesets_textsearch.go) rather
+// than a plain string: an earlier version of this field matched a bare websearch_to_tsquery
+// against a single, unscoped tsv column, which both search_tsv's field-scoped weighting and
+// ListChangesetsOpts.TextSearch's query shape have since made redundant. changeset_excerpts is
+// assumed to carry the same generated search_tsv column described there, maintained the same
+// way, so the two listings can share one text-search implementation instead of drifting apart.
+type ChangesetSearchOpts struct {
+	TextSearch     []ListChangesetsTextSearchExpr
+	Labels         []string
+	LabelsMatchAll bool
+
+	// SinceLamport restricts results to changesets whose edit_lamport is strictly greater than
+	// this value. It is a stable alternative to ListChangesetsOpts.Cursor (which is just the
+	// row id, and so says nothing about whether a row has actually changed since it was last
+	// read) for change-feed consumers that want "everything edited since I last looked".
+	SinceLamport int64
+
+	// LabelsAll, LabelsAny, and LabelsNot filter by the canonical, id-based ChangesetLabel
+	// entity (see store_changeset_labels.go) rather than the denormalized Labels []string
+	// above, which exists only to make excerpt listings fast to render. LabelsAll requires
+	// every listed label id; LabelsAny requires at least one; LabelsNot excludes changesets
+	// carrying any of the listed label ids.
+	LabelsAll []int64
+	LabelsAny []int64
+	LabelsNot []int64
+}
+
+// predicates returns the WHERE-clause fragments that apply o's filters against the
+// changeset_excerpts table (see store_changeset_excerpts.go for its tsvector and labels
+// columns). It returns nil when o is the zero value, so it's always safe to append its result
+// into another predicate list.
+func (o ChangesetSearchOpts) predicates() []*sqlf.Query {
+	var preds []*sqlf.Query
+
+	for _, expr := range o.TextSearch {
+		preds = append(preds, expr.toSQL())
+	}
+
+	if len(o.Labels) > 0 {
+		if o.LabelsMatchAll {
+			preds = append(preds, sqlf.Sprintf("labels @> %s", pq.Array(o.Labels)))
+		} else {
+			preds = append(preds, sqlf.Sprintf("labels && %s", pq.Array(o.Labels)))
+		}
+	}
+
+	if o.SinceLamport != 0 {
+		preds = append(preds, sqlf.Sprintf("edit_lamport > %s", o.SinceLamport))
+	}
+
+	for _, labelID := range o.LabelsAll {
+		preds = append(preds, sqlf.Sprintf(
+			"EXISTS (SELECT 1 FROM changeset_label_associations WHERE changeset_id = changeset_excerpts.id AND label_id = %s)", labelID))
+	}
+
+	if len(o.LabelsAny) > 0 {
+		preds = append(preds, sqlf.Sprintf(
+			"EXISTS (SELECT 1 FROM changeset_label_associations WHERE changeset_id = changeset_excerpts.id AND label_id = ANY(%s))", pq.Array(o.LabelsAny)))
+	}
+
+	if len(o.LabelsNot) > 0 {
+		preds = append(preds, sqlf.Sprintf(
+			"NOT EXISTS (SELECT 1 FROM changeset_label_associations WHERE changeset_id = changeset_excerpts.id AND label_id = ANY(%s))", pq.Array(o.LabelsNot)))
+	}
+
+	return preds
+}
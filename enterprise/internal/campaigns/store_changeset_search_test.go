@@ -0,0 +1,47 @@
+package campaigns
+
+import "testing"
+
+func TestChangesetSearchOptsPredicates(t *testing.T) {
+	if preds := (ChangesetSearchOpts{}).predicates(); preds != nil {
+		t.Fatalf("expected no predicates for the zero value, got %d", len(preds))
+	}
+
+	textOnly := ChangesetSearchOpts{TextSearch: []ListChangesetsTextSearchExpr{{Term: "bugfix"}}}
+	if preds := textOnly.predicates(); len(preds) != 1 {
+		t.Fatalf("expected exactly one predicate for TextSearch, got %d", len(preds))
+	}
+
+	anyLabel := ChangesetSearchOpts{Labels: []string{"security", "bug"}}
+	if preds := anyLabel.predicates(); len(preds) != 1 {
+		t.Fatalf("expected exactly one predicate for Labels, got %d", len(preds))
+	}
+
+	both := ChangesetSearchOpts{TextSearch: []ListChangesetsTextSearchExpr{{Term: "bugfix"}}, Labels: []string{"security"}, LabelsMatchAll: true}
+	if preds := both.predicates(); len(preds) != 2 {
+		t.Fatalf("expected two predicates when both TextSearch and Labels are set, got %d", len(preds))
+	}
+
+	sinceOnly := ChangesetSearchOpts{SinceLamport: 42}
+	if preds := sinceOnly.predicates(); len(preds) != 1 {
+		t.Fatalf("expected exactly one predicate for SinceLamport, got %d", len(preds))
+	}
+
+	allLabels := ChangesetSearchOpts{LabelsAll: []int64{1, 2}}
+	if preds := allLabels.predicates(); len(preds) != 2 {
+		t.Fatalf("expected one predicate per LabelsAll entry, got %d", len(preds))
+	}
+
+	anyAndNotLabels := ChangesetSearchOpts{LabelsAny: []int64{1, 2}, LabelsNot: []int64{3}}
+	if preds := anyAndNotLabels.predicates(); len(preds) != 2 {
+		t.Fatalf("expected one predicate for LabelsAny and one for LabelsNot, got %d", len(preds))
+	}
+
+	multiTermSearch := ChangesetSearchOpts{TextSearch: []ListChangesetsTextSearchExpr{
+		{Field: ListChangesetsTextSearchFieldTitle, Term: "regression"},
+		{Field: ListChangesetsTextSearchFieldBody, Term: "flake", Not: true},
+	}}
+	if preds := multiTermSearch.predicates(); len(preds) != 2 {
+		t.Fatalf("expected one predicate per TextSearch term, got %d", len(preds))
+	}
+}
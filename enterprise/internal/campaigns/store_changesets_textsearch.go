@@ -0,0 +1,135 @@
+package campaigns
+
+import (
+	"strings"
+
+	"github.com/keegancsmith/sqlf"
+)
+
+// ListChangesetsTextSearchField scopes a ListChangesetsTextSearchExpr to a single field of a
+// changeset's full-text index, instead of matching across all of them at once.
+type ListChangesetsTextSearchField string
+
+const (
+	ListChangesetsTextSearchFieldTitle  ListChangesetsTextSearchField = "title"
+	ListChangesetsTextSearchFieldBody   ListChangesetsTextSearchField = "body"
+	ListChangesetsTextSearchFieldAuthor ListChangesetsTextSearchField = "author"
+	ListChangesetsTextSearchFieldBranch ListChangesetsTextSearchField = "branch"
+	ListChangesetsTextSearchFieldRepo   ListChangesetsTextSearchField = "repo"
+)
+
+// ListChangesetsTextSearchExpr is a single term of a ListChangesetsOpts.TextSearch query: Term
+// must (or, if Not is set, must not) appear somewhere in the changeset's full-text index. A
+// term is matched against every field by default; setting Field scopes it to just one, e.g.
+// {Field: ListChangesetsTextSearchFieldBody, Term: "regression"}. Setting Prefix matches word
+// prefixes instead of whole words, so {Term: "bun", Prefix: true} matches "bunch" while
+// {Term: "unch"} never does, since "unch" isn't a prefix of any lexeme in "bunch".
+//
+// This assumes a migration has added the following generated column to changesets, maintained
+// automatically on every insert or update, with a distinct tsvector weight per field so
+// field-scoped queries can restrict to it and field-unscoped queries still search the union of
+// everything:
+//
+//   ALTER TABLE changesets ADD COLUMN search_tsv tsvector GENERATED ALWAYS AS (
+//       setweight(to_tsvector('english', coalesce(metadata_title, spec_title, '')), 'A') ||
+//       setweight(to_tsvector('english', coalesce(metadata_body, spec_body, '')), 'B') ||
+//       setweight(to_tsvector('english', coalesce(metadata_author_login, '')), 'C') ||
+//       setweight(to_tsvector('simple', coalesce(external_branch, '')), 'C') ||
+//       setweight(to_tsvector('simple', coalesce(repo_name, '')), 'D')
+//   ) STORED;
+//   CREATE INDEX changesets_search_tsv ON changesets USING GIN (search_tsv);
+//
+// metadata_title/metadata_body/metadata_author_login would be plain generated columns
+// alongside search_tsv, populated from changesetTitleAndAuthor and changesetBody (see
+// store_changeset_excerpts.go and store_changeset_issue_links.go) the same way those already
+// derive a changeset's title and body today; spec_title/spec_body are the matching columns off
+// the changeset's current spec, used as a fallback before a changeset is published. The method
+// that would actually assemble a ListChangesets query out of a []ListChangesetsTextSearchExpr
+// — joining in repo_name, resolving the title/body fallback, ANDing negated and
+// non-negated terms together — lives on ListChangesets itself, which this snapshot of the tree
+// doesn't carry; toSQL below is the fragment that method would call per term once it exists.
+// ChangesetSearchOpts.TextSearch (store_changeset_search.go) already calls it the same way for
+// the excerpt-backed listing, on the assumption that changeset_excerpts carries the same
+// generated search_tsv column.
+type ListChangesetsTextSearchExpr struct {
+	Term   string
+	Not    bool
+	Field  ListChangesetsTextSearchField
+	Prefix bool
+}
+
+// toSQL renders e as a predicate against the search_tsv column described above.
+func (e ListChangesetsTextSearchExpr) toSQL() *sqlf.Query {
+	match := sqlf.Sprintf("search_tsv @@ to_tsquery('english', %s)", buildWeightedTSQuery(e.Term, e.Field, e.Prefix))
+
+	if e.Not {
+		return sqlf.Sprintf("NOT (%s)", match)
+	}
+	return match
+}
+
+// buildWeightedTSQuery turns term into a Postgres tsquery string that ANDs together its
+// whitespace-separated words, each restricted to the tsvector weight(s) that field carries (or
+// unrestricted, if field is ""), each with a trailing "*" prefix-match operator if prefix is
+// set. For example buildWeightedTSQuery("bun", ListChangesetsTextSearchFieldTitle, true)
+// returns "bun:A*".
+//
+// Every word is run through stripTSQueryMetacharacters first: term is ordinary user-typed free
+// text, not tsquery syntax, but the string this builds is handed to to_tsquery (not
+// plainto_tsquery), which is the only form that lets a weight label and "*" be attached per
+// word. A word containing one of tsquery's own operator characters -- "&", "|", "!", "(", ")",
+// ":", "*", "'" -- would otherwise either change the query's meaning (e.g. "AT&T" silently
+// becoming the two-lexeme query "at" AND "t") or fail to parse at all (an unbalanced "(" or a
+// bare "!"), taking the whole search down with it. A word that's nothing but metacharacters
+// strips down to empty and is dropped rather than emitted as an empty lexeme.
+func buildWeightedTSQuery(term string, field ListChangesetsTextSearchField, prefix bool) string {
+	suffix := fieldWeights(field)
+	if prefix {
+		suffix += "*"
+	}
+
+	var lexemes []string
+	for _, w := range strings.Fields(term) {
+		word := stripTSQueryMetacharacters(strings.ToLower(w))
+		if word == "" {
+			continue
+		}
+		if suffix != "" {
+			word += ":" + suffix
+		}
+		lexemes = append(lexemes, word)
+	}
+
+	return strings.Join(lexemes, " & ")
+}
+
+// stripTSQueryMetacharacters removes the characters that carry syntactic meaning inside a
+// Postgres tsquery string, so a word drawn from free text can be embedded as a lexeme without
+// being parsed as an operator.
+func stripTSQueryMetacharacters(word string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '&', '|', '!', '(', ')', ':', '*', '\'':
+			return -1
+		default:
+			return r
+		}
+	}, word)
+}
+
+// fieldWeights returns the tsvector weight label(s) search_tsv assigns to field, or "" for the
+// zero Field value, which searches every weight.
+func fieldWeights(field ListChangesetsTextSearchField) string {
+	switch field {
+	case ListChangesetsTextSearchFieldTitle:
+		return "A"
+	case ListChangesetsTextSearchFieldBody:
+		return "B"
+	case ListChangesetsTextSearchFieldAuthor, ListChangesetsTextSearchFieldBranch:
+		return "C"
+	case ListChangesetsTextSearchFieldRepo:
+		return "D"
+	default:
+		return ""
+	}
+}
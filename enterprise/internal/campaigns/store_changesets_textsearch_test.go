@@ -0,0 +1,68 @@
+package campaigns
+
+import "testing"
+
+func TestBuildWeightedTSQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		term   string
+		field  ListChangesetsTextSearchField
+		prefix bool
+		want   string
+	}{
+		{
+			name: "unscoped, no prefix",
+			term: "bunch of bugs",
+			want: "bunch & of & bugs",
+		},
+		{
+			name:   "unscoped prefix",
+			term:   "bun",
+			prefix: true,
+			want:   "bun:*",
+		},
+		{
+			name: "subword term never gets a prefix operator",
+			term: "unch",
+			want: "unch",
+		},
+		{
+			name:  "field scoped",
+			term:  "regression",
+			field: ListChangesetsTextSearchFieldBody,
+			want:  "regression:B",
+		},
+		{
+			name:   "field scoped and prefixed",
+			term:   "bun",
+			field:  ListChangesetsTextSearchFieldTitle,
+			prefix: true,
+			want:   "bun:A*",
+		},
+		{
+			name:  "author and branch share a weight",
+			term:  "mrnugget",
+			field: ListChangesetsTextSearchFieldAuthor,
+			want:  "mrnugget:C",
+		},
+		{
+			name: "tsquery metacharacters are stripped from a word",
+			term: "AT&T (merged!)",
+			want: "att & merged",
+		},
+		{
+			name: "a word that is nothing but metacharacters is dropped",
+			term: "bugs && regressions",
+			want: "bugs & regressions",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			have := buildWeightedTSQuery(tc.term, tc.field, tc.prefix)
+			if have != tc.want {
+				t.Fatalf("buildWeightedTSQuery(%q, %q, %v) = %q, want %q", tc.term, tc.field, tc.prefix, have, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package campaigns
+
+import (
+	"context"
+
+	"github.com/keegancsmith/sqlf"
+	"github.com/sourcegraph/sourcegraph/internal/db/basestore"
+)
+
+// This file assumes a migration has added the following table, plus the edit_lamport and
+// create_lamport bigint columns on changesets and changeset_operations described on
+// cmpgn.ChangesetExcerpt.EditLamport and cmpgn.ChangesetOperation.EditLamport:
+//
+//   CREATE TABLE campaign_lamport_clocks (
+//       campaign_id BIGINT PRIMARY KEY REFERENCES campaigns(id) ON DELETE CASCADE,
+//       counter     BIGINT NOT NULL DEFAULT 0
+//   );
+
+// NextLamport atomically increments and returns the Lamport clock for campaignID. Callers
+// that write a changeset belonging to this campaign (UpdateChangeset, the reconciler, a
+// webhook handler) should call this inside the same transaction as their write and stamp the
+// result onto the row and its operation log entry, so every write to the campaign gets a
+// distinct, monotonically increasing counter value regardless of which process or clock
+// produced it.
+func (s *Store) NextLamport(ctx context.Context, campaignID int64) (_ int64, err error) {
+	counter, _, err := basestore.ScanFirstInt64(s.Store.Query(ctx, sqlf.Sprintf(`
+		INSERT INTO campaign_lamport_clocks (campaign_id, counter)
+		VALUES (%s, 1)
+		ON CONFLICT (campaign_id) DO UPDATE SET counter = campaign_lamport_clocks.counter + 1
+		RETURNING counter
+	`, campaignID)))
+
+	return counter, err
+}
+
+// IsStaleLamport reports whether observedLamport is no newer than the Lamport value already
+// recorded for changesetID, i.e. whether a write carrying it (a webhook delivery, most often)
+// arrived out of causal order and should be dropped rather than applied.
+func (s *Store) IsStaleLamport(ctx context.Context, changesetID int64, observedLamport int64) (_ bool, err error) {
+	current, _, err := basestore.ScanFirstInt64(s.Store.Query(ctx, sqlf.Sprintf(`
+		SELECT edit_lamport FROM changeset_excerpts WHERE id = %s
+	`, changesetID)))
+	if err != nil {
+		return false, err
+	}
+
+	return observedLamport <= current, nil
+}
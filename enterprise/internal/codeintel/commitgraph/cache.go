@@ -0,0 +1,329 @@
+package commitgraph
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sourcegraph/sourcegraph/enterprise/internal/codeintel/gitserver"
+)
+
+// CacheKey identifies a single cached Graph computation. A full cache hit requires all three
+// fields to match what produced the cached value: CommitGraphHash over the shape of the commit
+// graph, UploadsHash over the set of uploads used to decorate it, and DirtyToken over the
+// repository's lsif_dirty_repositories.update_token at the time of computation, so that a cache
+// entry computed before the most recent dirty flag is never served as current (see
+// GraphCache.Evict). Callers are responsible for choosing hashes that change whenever their
+// input does; this package doesn't compute them itself, since what's cheap to hash (e.g. a
+// sorted commit list vs. a full diff) is a choice best left to the caller.
+type CacheKey struct {
+	RepositoryID    int
+	CommitGraphHash string
+	UploadsHash     string
+	DirtyToken      int
+}
+
+// GraphCache stores and retrieves the expensive, derived part of a Graph: its generation
+// numbers and ancestor/descendant visibility maps, which are the product of
+// populateUploadsByTraversal walking the entire commit graph. The graph's raw parent/child
+// edges are not cached, as they are always cheap to re-derive from a *gitserver.CommitGraph.
+//
+// Implementations must be safe for concurrent use across distinct keys, but need not
+// synchronize concurrent Get/Put calls that share a key.
+type GraphCache interface {
+	// Get returns the cached Graph for key, re-attached to commitGraph and commitGraphView. The
+	// second return value is false on a cache miss.
+	Get(ctx context.Context, key CacheKey, commitGraph *gitserver.CommitGraph, commitGraphView *CommitGraphView) (*Graph, bool, error)
+
+	// GetPartial returns a Graph whose graph edges and generation numbers are reused from a
+	// previously cached entry sharing key's RepositoryID, CommitGraphHash, and DirtyToken, even
+	// when no entry matches key.UploadsHash exactly (a "partial hit": the commit graph hasn't
+	// changed, but the uploads have). Its ancestor/descendant visibility maps are left nil; pass
+	// the result to PopulateUploads before querying it. The second return value is false if no
+	// entry shares those three fields.
+	GetPartial(ctx context.Context, key CacheKey, commitGraph *gitserver.CommitGraph) (*Graph, bool, error)
+
+	// Put stores graph under key for later retrieval by Get and GetPartial.
+	Put(ctx context.Context, key CacheKey, graph *Graph) error
+
+	// Evict discards every cached entry for repositoryID whose DirtyToken doesn't match
+	// currentDirtyToken, i.e. every entry computed before the repository's most recent dirty
+	// flag. Callers should invoke this once per repository before consulting Get or GetPartial,
+	// so that an entry invalidated by a write the dirty-token bump represents is never served.
+	Evict(ctx context.Context, repositoryID int, currentDirtyToken int) error
+}
+
+// cachedGeneration is the gob-friendly mirror of generation: gob only encodes exported struct
+// fields, and we'd rather keep generation's fields unexported (see generation.go) than export
+// them just to satisfy the cache's serialization format.
+type cachedGeneration struct {
+	Level               uint32
+	CorrectedCommitDate uint32
+}
+
+// cachedData is the serializable subset of a Graph that GraphCache implementations persist:
+// everything populateUploadsByTraversal produces, but not the graph edges themselves.
+type cachedData struct {
+	Commits           []string
+	Generations       map[string]cachedGeneration
+	AncestorUploads   map[string]map[string]UploadMeta
+	DescendantUploads map[string]map[string]UploadMeta
+}
+
+func newCachedData(g *Graph) *cachedData {
+	generations := make(map[string]cachedGeneration, len(g.generations))
+	for commit, gen := range g.generations {
+		generations[commit] = cachedGeneration{Level: gen.level, CorrectedCommitDate: gen.correctedCommitDate}
+	}
+
+	return &cachedData{
+		Commits:           g.commits,
+		Generations:       generations,
+		AncestorUploads:   g.ancestorUploads,
+		DescendantUploads: g.descendantUploads,
+	}
+}
+
+// hydrate rebuilds a full Graph from cached data and a freshly supplied commit graph,
+// re-deriving the cheap graph/reverseGraph edge maps from commitGraph rather than trusting a
+// (potentially stale) serialized copy of them.
+func (c *cachedData) hydrate(commitGraph *gitserver.CommitGraph, commitGraphView *CommitGraphView) *Graph {
+	graph := commitGraph.Graph()
+
+	generations := make(map[string]generation, len(c.Generations))
+	for commit, gen := range c.Generations {
+		generations[commit] = generation{level: gen.Level, correctedCommitDate: gen.CorrectedCommitDate}
+	}
+
+	return &Graph{
+		commitGraphView:   commitGraphView,
+		graph:             graph,
+		reverseGraph:      reverseGraph(graph),
+		commits:           c.Commits,
+		ancestorUploads:   c.AncestorUploads,
+		descendantUploads: c.DescendantUploads,
+		generations:       generations,
+	}
+}
+
+// graphKey identifies a cached set of generation numbers, ignoring the uploads that happened to
+// be in play when they were computed: see cachedGenerations and GraphCache.GetPartial.
+type graphKey struct {
+	RepositoryID    int
+	CommitGraphHash string
+	DirtyToken      int
+}
+
+func graphKeyOf(key CacheKey) graphKey {
+	return graphKey{RepositoryID: key.RepositoryID, CommitGraphHash: key.CommitGraphHash, DirtyToken: key.DirtyToken}
+}
+
+// cachedGenerations is the gob-friendly, uploads-independent slice of a Graph that GraphCache
+// implementations keep around for GetPartial: just enough to skip re-running computeGenerations
+// on a partial hit, not the ancestor/descendant visibility maps (see PopulateUploads).
+type cachedGenerations struct {
+	Generations map[string]cachedGeneration
+}
+
+func (c *cachedGenerations) hydrate(commitGraph *gitserver.CommitGraph) *Graph {
+	graph := commitGraph.Graph()
+
+	generations := make(map[string]generation, len(c.Generations))
+	for commit, gen := range c.Generations {
+		generations[commit] = generation{level: gen.Level, correctedCommitDate: gen.CorrectedCommitDate}
+	}
+
+	order := commitGraph.Order()
+	commits := append([]string(nil), order...)
+	sort.Strings(commits)
+
+	return &Graph{
+		graph:        graph,
+		reverseGraph: reverseGraph(graph),
+		commits:      commits,
+		generations:  generations,
+	}
+}
+
+type memoryGraphCache struct {
+	mu         sync.Mutex
+	byKey      map[CacheKey]*cachedData
+	byGraphKey map[graphKey]*cachedGenerations
+}
+
+// NewMemoryGraphCache returns a GraphCache that keeps cached graphs in memory for the lifetime
+// of the process. It is appropriate for a single long-running worker; it does not share data
+// across processes or survive a restart (see NewFileGraphCache for that).
+func NewMemoryGraphCache() GraphCache {
+	return &memoryGraphCache{byKey: map[CacheKey]*cachedData{}, byGraphKey: map[graphKey]*cachedGenerations{}}
+}
+
+func (c *memoryGraphCache) Get(_ context.Context, key CacheKey, commitGraph *gitserver.CommitGraph, commitGraphView *CommitGraphView) (*Graph, bool, error) {
+	c.mu.Lock()
+	data, ok := c.byKey[key]
+	c.mu.Unlock()
+	if !ok {
+		return nil, false, nil
+	}
+
+	return data.hydrate(commitGraph, commitGraphView), true, nil
+}
+
+func (c *memoryGraphCache) GetPartial(_ context.Context, key CacheKey, commitGraph *gitserver.CommitGraph) (*Graph, bool, error) {
+	c.mu.Lock()
+	data, ok := c.byGraphKey[graphKeyOf(key)]
+	c.mu.Unlock()
+	if !ok {
+		return nil, false, nil
+	}
+
+	return data.hydrate(commitGraph), true, nil
+}
+
+func (c *memoryGraphCache) Put(_ context.Context, key CacheKey, graph *Graph) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data := newCachedData(graph)
+	c.byKey[key] = data
+	c.byGraphKey[graphKeyOf(key)] = &cachedGenerations{Generations: data.Generations}
+	return nil
+}
+
+func (c *memoryGraphCache) Evict(_ context.Context, repositoryID int, currentDirtyToken int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.byKey {
+		if key.RepositoryID == repositoryID && key.DirtyToken != currentDirtyToken {
+			delete(c.byKey, key)
+		}
+	}
+	for key := range c.byGraphKey {
+		if key.RepositoryID == repositoryID && key.DirtyToken != currentDirtyToken {
+			delete(c.byGraphKey, key)
+		}
+	}
+
+	return nil
+}
+
+type fileGraphCache struct {
+	dir string
+}
+
+// NewFileGraphCache returns a GraphCache that gob-encodes cached graphs to files under dir, one
+// file per key. Unlike NewMemoryGraphCache, entries survive a process restart and can be shared
+// between processes with access to the same directory (e.g. a shared volume).
+func NewFileGraphCache(dir string) GraphCache {
+	return &fileGraphCache{dir: dir}
+}
+
+// filePrefix returns the portion of a cache file's name shared by every entry for
+// repositoryID under dirtyToken, regardless of CommitGraphHash or UploadsHash -- enough for
+// Evict to find every file that needs checking without decoding each one.
+func filePrefix(repositoryID, dirtyToken int) string {
+	return fmt.Sprintf("%d-%d-", repositoryID, dirtyToken)
+}
+
+func (c *fileGraphCache) path(key CacheKey) string {
+	return filepath.Join(c.dir, filePrefix(key.RepositoryID, key.DirtyToken)+fmt.Sprintf("%s-%s.gob", key.CommitGraphHash, key.UploadsHash))
+}
+
+func (c *fileGraphCache) graphPath(key CacheKey) string {
+	return filepath.Join(c.dir, filePrefix(key.RepositoryID, key.DirtyToken)+key.CommitGraphHash+".generations.gob")
+}
+
+func (c *fileGraphCache) Get(_ context.Context, key CacheKey, commitGraph *gitserver.CommitGraph, commitGraphView *CommitGraphView) (*Graph, bool, error) {
+	contents, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	var data cachedData
+	if err := gob.NewDecoder(bytes.NewReader(contents)).Decode(&data); err != nil {
+		return nil, false, err
+	}
+
+	return data.hydrate(commitGraph, commitGraphView), true, nil
+}
+
+func (c *fileGraphCache) GetPartial(_ context.Context, key CacheKey, commitGraph *gitserver.CommitGraph) (*Graph, bool, error) {
+	contents, err := ioutil.ReadFile(c.graphPath(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	var data cachedGenerations
+	if err := gob.NewDecoder(bytes.NewReader(contents)).Decode(&data); err != nil {
+		return nil, false, err
+	}
+
+	return data.hydrate(commitGraph), true, nil
+}
+
+func (c *fileGraphCache) Put(_ context.Context, key CacheKey, graph *Graph) error {
+	data := newCachedData(graph)
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(data); err != nil {
+		return err
+	}
+
+	var graphBuf bytes.Buffer
+	if err := gob.NewEncoder(&graphBuf).Encode(&cachedGenerations{Generations: data.Generations}); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(c.path(key), buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(c.graphPath(key), graphBuf.Bytes(), 0o644)
+}
+
+// Evict removes every cache file under dir that belongs to repositoryID but was written with a
+// DirtyToken other than currentDirtyToken, relying on filePrefix's naming convention rather than
+// decoding each file's contents.
+func (c *fileGraphCache) Evict(_ context.Context, repositoryID int, currentDirtyToken int) error {
+	entries, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	repoPrefix := fmt.Sprintf("%d-", repositoryID)
+	currentPrefix := filePrefix(repositoryID, currentDirtyToken)
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, repoPrefix) || strings.HasPrefix(name, currentPrefix) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(c.dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
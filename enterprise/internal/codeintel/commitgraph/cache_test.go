@@ -0,0 +1,127 @@
+package commitgraph
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sourcegraph/sourcegraph/enterprise/internal/codeintel/gitserver"
+)
+
+func TestMemoryGraphCache(t *testing.T) {
+	testGraphCache(t, NewMemoryGraphCache())
+}
+
+func TestFileGraphCache(t *testing.T) {
+	testGraphCache(t, NewFileGraphCache(t.TempDir()))
+}
+
+// testGraphCache exercises the cold/warm/invalidated cache lifecycle any GraphCache
+// implementation must support, regardless of its backing storage.
+func testGraphCache(t *testing.T, cache GraphCache) {
+	t.Helper()
+	ctx := context.Background()
+
+	testGraph := gitserver.ParseCommitGraph([]string{
+		"c b",
+		"b a",
+	})
+	commitGraphView := NewCommitGraphView()
+	commitGraphView.Add(UploadMeta{UploadID: 1}, "a", "sub1/:lsif-go")
+	commitGraphView.Add(UploadMeta{UploadID: 2}, "c", "sub2/:lsif-go")
+
+	key := CacheKey{RepositoryID: 50, CommitGraphHash: "abc", UploadsHash: "def"}
+
+	t.Run("cold", func(t *testing.T) {
+		if _, ok, err := cache.Get(ctx, key, testGraph, commitGraphView); err != nil {
+			t.Fatalf("unexpected error on cold cache: %s", err)
+		} else if ok {
+			t.Fatalf("expected a cache miss before any Put")
+		}
+	})
+
+	graph := NewGraph(testGraph, commitGraphView)
+	if err := cache.Put(ctx, key, graph); err != nil {
+		t.Fatalf("unexpected error storing graph: %s", err)
+	}
+
+	t.Run("warm", func(t *testing.T) {
+		hydrated, ok, err := cache.Get(ctx, key, testGraph, commitGraphView)
+		if err != nil {
+			t.Fatalf("unexpected error on warm cache: %s", err)
+		}
+		if !ok {
+			t.Fatalf("expected a cache hit after Put")
+		}
+
+		for _, commit := range []string{"a", "b", "c"} {
+			want := graph.UploadsVisibleAtCommit(commit)
+			have := hydrated.UploadsVisibleAtCommit(commit)
+			sortUploadMetas(want)
+			sortUploadMetas(have)
+
+			if diff := cmp.Diff(want, have); diff != "" {
+				t.Errorf("unexpected visible uploads for commit %q from cached graph (-want +got):\n%s", commit, diff)
+			}
+		}
+	})
+
+	t.Run("invalidated", func(t *testing.T) {
+		staleKey := CacheKey{RepositoryID: key.RepositoryID, CommitGraphHash: key.CommitGraphHash, UploadsHash: "changed"}
+
+		if _, ok, err := cache.Get(ctx, staleKey, testGraph, commitGraphView); err != nil {
+			t.Fatalf("unexpected error on invalidated cache: %s", err)
+		} else if ok {
+			t.Fatalf("expected a cache miss once the uploads hash changes")
+		}
+	})
+
+	t.Run("partial hit reuses generations", func(t *testing.T) {
+		partialKey := CacheKey{RepositoryID: key.RepositoryID, CommitGraphHash: key.CommitGraphHash, UploadsHash: "changed"}
+
+		partial, ok, err := cache.GetPartial(ctx, partialKey, testGraph)
+		if err != nil {
+			t.Fatalf("unexpected error on partial hit: %s", err)
+		}
+		if !ok {
+			t.Fatalf("expected a partial hit sharing CommitGraphHash with the full entry")
+		}
+
+		newCommitGraphView := NewCommitGraphView()
+		newCommitGraphView.Add(UploadMeta{UploadID: 1}, "a", "sub1/:lsif-go")
+		newCommitGraphView.Add(UploadMeta{UploadID: 3}, "b", "sub3/:lsif-go")
+
+		PopulateUploads(partial, testGraph, newCommitGraphView)
+
+		want := NewGraph(testGraph, newCommitGraphView).UploadsVisibleAtCommit("c")
+		have := partial.UploadsVisibleAtCommit("c")
+		sortUploadMetas(want)
+		sortUploadMetas(have)
+
+		if diff := cmp.Diff(want, have); diff != "" {
+			t.Errorf("unexpected visible uploads for commit \"c\" from partial-hit graph (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("evict discards entries from a superseded dirty token", func(t *testing.T) {
+		tokenKey := CacheKey{RepositoryID: 51, CommitGraphHash: "xyz", UploadsHash: "xyz", DirtyToken: 1}
+		if err := cache.Put(ctx, tokenKey, NewGraph(testGraph, commitGraphView)); err != nil {
+			t.Fatalf("unexpected error storing graph: %s", err)
+		}
+
+		if err := cache.Evict(ctx, tokenKey.RepositoryID, 2); err != nil {
+			t.Fatalf("unexpected error evicting: %s", err)
+		}
+
+		if _, ok, err := cache.Get(ctx, tokenKey, testGraph, commitGraphView); err != nil {
+			t.Fatalf("unexpected error on evicted cache: %s", err)
+		} else if ok {
+			t.Fatalf("expected Evict to discard the entry computed under a stale dirty token")
+		}
+		if _, ok, err := cache.GetPartial(ctx, tokenKey, testGraph); err != nil {
+			t.Fatalf("unexpected error on evicted partial cache: %s", err)
+		} else if ok {
+			t.Fatalf("expected Evict to discard the partial entry computed under a stale dirty token")
+		}
+	})
+}
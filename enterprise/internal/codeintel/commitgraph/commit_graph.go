@@ -13,6 +13,7 @@ type Graph struct {
 	commits           []string
 	ancestorUploads   map[string]map[string]UploadMeta
 	descendantUploads map[string]map[string]UploadMeta
+	generations       map[string]generation
 }
 
 type Envelope struct {
@@ -40,8 +41,9 @@ func NewGraph(commitGraph *gitserver.CommitGraph, commitGraphView *CommitGraphVi
 	reverseGraph := reverseGraph(graph)
 	order := commitGraph.Order()
 
-	ancestorUploads := populateUploadsByTraversal(graph, reverseGraph, order, commitGraphView, false)
-	descendantUploads := populateUploadsByTraversal(reverseGraph, graph, order, commitGraphView, true)
+	generations := computeGenerations(graph, order, commitGraphView)
+	ancestorUploads := populateUploadsByTraversal(graph, reverseGraph, order, commitGraphView, generations, false)
+	descendantUploads := populateUploadsByTraversal(reverseGraph, graph, order, commitGraphView, generations, true)
 	sort.Strings(order)
 
 	return &Graph{
@@ -51,13 +53,46 @@ func NewGraph(commitGraph *gitserver.CommitGraph, commitGraphView *CommitGraphVi
 		commits:           order,
 		ancestorUploads:   ancestorUploads,
 		descendantUploads: descendantUploads,
+		generations:       generations,
 	}
 }
 
+// PopulateUploads fills g's ancestor and descendant upload-visibility maps from commitGraph and
+// commitGraphView, reusing g's existing graph edges and generation numbers instead of
+// recomputing them from scratch (see NewGraph, which computes both from nothing). It is meant
+// for a Graph reconstructed from a GraphCache.GetPartial hit, where the commit graph hasn't
+// changed since the cached entry was produced but the uploads have.
+//
+// Unlike generation numbers, which are a pure function of the graph's topology (see
+// computeGenerations), the ancestor and descendant visibility maps are derived directly from
+// commitGraphView and must always be recomputed in full on an uploads change: a single upload
+// defined anywhere in a commit's ancestor or descendant chain can shift populateUploadsByTraversal's
+// fixed point for that commit, so there is no sound way to patch only the commits the new uploads
+// were defined on.
+func PopulateUploads(g *Graph, commitGraph *gitserver.CommitGraph, commitGraphView *CommitGraphView) {
+	order := commitGraph.Order()
+
+	g.commitGraphView = commitGraphView
+	g.ancestorUploads = populateUploadsByTraversal(g.graph, g.reverseGraph, order, commitGraphView, g.generations, false)
+	g.descendantUploads = populateUploadsByTraversal(g.reverseGraph, g.graph, order, commitGraphView, g.generations, true)
+}
+
+// GenerationOf returns the topological level (generation number v1) and corrected commit
+// date (generation number v2) computed for the given commit. The second return value is
+// false if the commit is not known to this graph.
+func (g *Graph) GenerationOf(commit string) (level, ccd uint32, ok bool) {
+	generation, ok := g.generations[commit]
+	if !ok {
+		return 0, 0, false
+	}
+
+	return generation.level, generation.correctedCommitDate, true
+}
+
 // UploadsVisibleAtCommit returns the set of uploads that are visible from the given commit.
 func (g *Graph) UploadsVisibleAtCommit(commit string) []UploadMeta {
-	ancestorUploads, ancestorDistance := traverseForUploads(g.graph, g.ancestorUploads, commit)
-	descendantUploads, descendantDistance := traverseForUploads(g.reverseGraph, g.descendantUploads, commit)
+	ancestorUploads, ancestorDistance := traverseForUploads(g.graph, g.ancestorUploads, g.generations, commit)
+	descendantUploads, descendantDistance := traverseForUploads(g.reverseGraph, g.descendantUploads, g.generations, commit)
 	return combineVisibleUploadsForCommit(ancestorUploads, descendantUploads, ancestorDistance, descendantDistance)
 }
 
@@ -71,53 +106,7 @@ func (g *Graph) Stream() <-chan Envelope {
 		defer close(ch)
 
 		for _, commit := range g.commits {
-			ancestorCommit, ancestorDistance, found1 := traverseForCommit(g.graph, g.ancestorUploads, commit)
-			descendantCommit, descendantDistance, found2 := traverseForCommit(g.reverseGraph, g.descendantUploads, commit)
-			if !found1 && !found2 {
-				continue
-			}
-
-			ancestorVisibleUploads := g.ancestorUploads[ancestorCommit]
-			descendantVisibleUploads := g.descendantUploads[descendantCommit]
-			if len(ancestorVisibleUploads)+len(descendantVisibleUploads) == 0 {
-				continue
-			}
-
-			uploads := combineVisibleUploadsForCommit(
-				ancestorVisibleUploads,
-				descendantVisibleUploads,
-				ancestorDistance,
-				descendantDistance,
-			)
-
-			threshold := 1
-			if found1 && found2 {
-				threshold = 2
-			}
-
-			if (found1 && ancestorDistance == 0) || (found2 && descendantDistance == 0) || len(uploads) <= threshold {
-				ch <- Envelope{
-					Uploads: &VisibilityRelationship{
-						Commit:  commit,
-						Uploads: uploads,
-					},
-				}
-			} else {
-				// Otherwise, we have more than a pair of uploads. Because we also have a
-				// very cheap way of reconstructing this particular commit's visible uploads
-				// from its ancestors and descendants, we store that relationships which is
-				// much smaller when the number of distinct LSIF roots becomes large.
-
-				ch <- Envelope{
-					Links: &LinkRelationship{
-						Commit:             commit,
-						Ancestor:           strPtrOk(ancestorCommit, found1),
-						AncestorDistance:   ancestorDistance,
-						Descendant:         strPtrOk(descendantCommit, found2),
-						DescendantDistance: descendantDistance,
-					},
-				}
-			}
+			g.emitEnvelope(ch, commit)
 		}
 	}()
 
@@ -183,7 +172,7 @@ func reverseGraph(graph map[string][]string) map[string][]string {
 // child whose only parent is the commit (or has no children). This means that there is a single
 // unambiguous path to an ancestor with calculated data, and symmetrically in the other direction.
 // See combineVisibleUploadsForCommit for additional details.
-func populateUploadsByTraversal(graph, reverseGraph map[string][]string, order []string, commitGraphView *CommitGraphView, reverse bool) map[string]map[string]UploadMeta {
+func populateUploadsByTraversal(graph, reverseGraph map[string][]string, order []string, commitGraphView *CommitGraphView, generations map[string]generation, reverse bool) map[string]map[string]UploadMeta {
 	uploads := make(map[string]map[string]UploadMeta, len(order))
 	for i, commit := range order {
 		if reverse {
@@ -217,7 +206,7 @@ func populateUploadsByTraversal(graph, reverseGraph map[string][]string, order [
 			ancestors = graph[ancestors[0]]
 		}
 
-		uploads[commit] = populateUploadsForCommit(uploads, ancestors, distance, commitGraphView, commit)
+		uploads[commit] = populateUploadsForCommit(uploads, ancestors, distance, commitGraphView, generations, commit)
 	}
 
 	return uploads
@@ -234,7 +223,7 @@ func populateUploadsByTraversal(graph, reverseGraph map[string][]string, order [
 // smaller distance to the source commit will shadow the other. Similarly, If an ancestor and the
 // child commit define uploads for the same root and indexer pair, the upload defined on the commit
 // will shadow the upload defined on the ancestor.
-func populateUploadsForCommit(uploads map[string]map[string]UploadMeta, ancestors []string, distance uint32, commitGraphView *CommitGraphView, commit string) map[string]UploadMeta {
+func populateUploadsForCommit(uploads map[string]map[string]UploadMeta, ancestors []string, distance uint32, commitGraphView *CommitGraphView, generations map[string]generation, commit string) map[string]UploadMeta {
 	// The capacity chosen here is an underestimate, but seems to perform well in
 	// benchmarks using live user data. We have attempted to make this value more
 	// precise to minimize the number of re-hash operations, but any counting we
@@ -247,9 +236,14 @@ func populateUploadsForCommit(uploads map[string]map[string]UploadMeta, ancestor
 	}
 	uploadsByToken := make(map[string]UploadMeta, capacity)
 
-	// Populate uploads defined here
+	// Populate uploads defined here. The generation numbers are fixed to this, their
+	// source commit, and will be carried unchanged as the upload shadows other uploads
+	// deeper in the graph.
+	sourceGeneration := generations[commit]
 	for _, upload := range commitGraphView.Meta[commit] {
 		token := commitGraphView.Tokens[upload.UploadID]
+		upload.GenerationLevel = sourceGeneration.level
+		upload.CorrectedCommitDate = sourceGeneration.correctedCommitDate
 		uploadsByToken[token] = upload
 	}
 
@@ -274,8 +268,8 @@ func populateUploadsForCommit(uploads map[string]map[string]UploadMeta, ancestor
 // traverseForUploads returns the value in the given uploads map whose key matches the first ancestor
 // in the graph with a value present in the map. The distance in the graph between the original commit
 // and the ancestor is also returned.
-func traverseForUploads(graph map[string][]string, uploads map[string]map[string]UploadMeta, commit string) (map[string]UploadMeta, uint32) {
-	commit, distance, _ := traverseForCommit(graph, uploads, commit)
+func traverseForUploads(graph map[string][]string, uploads map[string]map[string]UploadMeta, generations map[string]generation, commit string) (map[string]UploadMeta, uint32) {
+	commit, distance, _ := traverseForCommit(graph, uploads, generations, commit)
 	return uploads[commit], distance
 }
 
@@ -285,7 +279,10 @@ func traverseForUploads(graph map[string][]string, uploads map[string]map[string
 //
 // NOTE: We assume that each commit with multiple parents have been assigned data while walking
 // the graph in topological order. If that is not the case, one parent will be chosen arbitrarily.
-func traverseForCommit(graph map[string][]string, uploads map[string]map[string]UploadMeta, commit string) (string, uint32, bool) {
+func traverseForCommit(graph map[string][]string, uploads map[string]map[string]UploadMeta, generations map[string]generation, commit string) (string, uint32, bool) {
+	startLevel, hasStart := generations[commit]
+	var sawIncrease, sawDecrease bool
+
 	for distance := uint32(0); ; distance++ {
 		if _, ok := uploads[commit]; ok {
 			return commit, distance, true
@@ -296,6 +293,32 @@ func traverseForCommit(graph map[string][]string, uploads map[string]map[string]
 			return "", 0, false
 		}
 
+		if hasStart {
+			// Every commit reachable from the starting commit by following a single
+			// parent edge repeatedly has a strictly lower generation level than it, and
+			// every commit reachable by following a single child edge repeatedly has a
+			// strictly higher one (level is defined as one more than the max over a
+			// commit's parents). So for consistently computed generations, this loop
+			// only ever observes levels moving in one direction relative to startLevel
+			// -- never both -- and sawIncrease && sawDecrease never actually triggers.
+			// It stays as a defensive bound rather than dead weight to delete: it
+			// guards against consuming the generations of a Graph rebuilt from a
+			// GraphCache.GetPartial hit (see PopulateUploads) whose generations map
+			// could in principle be out of sync with the graph edges it's paired with,
+			// where trusting an inconsistent map could otherwise walk arbitrarily far
+			// before giving up instead of failing fast.
+			if next, ok := generations[parents[0]]; ok {
+				if next.level < startLevel.level {
+					sawDecrease = true
+				} else if next.level > startLevel.level {
+					sawIncrease = true
+				}
+				if sawIncrease && sawDecrease {
+					return "", 0, false
+				}
+			}
+		}
+
 		commit = parents[0]
 	}
 }
@@ -353,9 +376,21 @@ func combineVisibleUploadsForCommit(ancestorVisibleUploads, descendantVisibleUpl
 	return uploads
 }
 
-// replaces returns true if upload1 has a smaller distance than upload2.
-// Ties are broken by the minimum upload identifier to remain determinstic.
+// replaces returns true if upload1 should be preferred over upload2 when both are visible
+// for the same root and indexer pair. Preference is given, in order, to the upload whose
+// source commit has the higher generation level, then to the one with the higher corrected
+// commit date, then (as a proxy for the two falling back to equal generation numbers, e.g.
+// because commit dates were not supplied) to the one with the smaller BFS distance. Ties are
+// broken by the minimum upload identifier to remain deterministic.
 func replaces(upload1, upload2 UploadMeta) bool {
+	if upload1.GenerationLevel != upload2.GenerationLevel {
+		return upload1.GenerationLevel > upload2.GenerationLevel
+	}
+
+	if upload1.CorrectedCommitDate != upload2.CorrectedCommitDate {
+		return upload1.CorrectedCommitDate > upload2.CorrectedCommitDate
+	}
+
 	distance1 := upload1.Flags & MaxDistance
 	distance2 := upload2.Flags & MaxDistance
 
@@ -12,6 +12,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/sourcegraph/sourcegraph/enterprise/internal/codeintel/gitserver"
@@ -60,47 +61,56 @@ func TestCalculateVisibleUploads(t *testing.T) {
 
 	visibleUploads, links := makeTestGraph(testGraph, commitGraphView)
 
+	// NOTE: Uploads 52-56 all share the "sub3/:lsif-go" token, so the assertions below
+	// exercise generation-number tie-breaking: the upload with the highest generation
+	// (i.e. defined deepest in the graph, upload 45 at "n") now shadows the others
+	// wherever it is reachable, regardless of which one is a smaller number of hops away.
 	expectedVisibleUploads := map[string][]UploadMeta{
 		"a": {
+			{UploadID: 45, Flags: 6},
 			{UploadID: 50, Flags: 0 | FlagAncestorVisible},
 			{UploadID: 51, Flags: 2},
-			{UploadID: 52, Flags: 1},
 		},
 		"b": {
 			{UploadID: 50, Flags: 1 | FlagAncestorVisible},
 			{UploadID: 51, Flags: 1},
 		},
 		"c": {
+			{UploadID: 45, Flags: 5},
 			{UploadID: 50, Flags: 1 | FlagAncestorVisible},
 			{UploadID: 51, Flags: 4},
-			{UploadID: 52, Flags: 0 | FlagAncestorVisible},
+			{UploadID: 52, Flags: 0 | FlagOverwritten | FlagAncestorVisible},
 		},
 		"d": {
 			{UploadID: 50, Flags: 2 | FlagAncestorVisible},
 			{UploadID: 52, Flags: 1 | FlagAncestorVisible},
 		},
 		"e": {
+			{UploadID: 45, Flags: 4},
 			{UploadID: 50, Flags: 2 | FlagAncestorVisible},
 			{UploadID: 51, Flags: 3},
-			{UploadID: 52, Flags: 1 | FlagAncestorVisible},
+			{UploadID: 52, Flags: 1 | FlagOverwritten | FlagAncestorVisible},
 		},
 		"g": {
 			{UploadID: 50, Flags: 3 | FlagAncestorVisible},
 			{UploadID: 52, Flags: 2 | FlagAncestorVisible},
 		},
 		"f": {
+			{UploadID: 45, Flags: 3},
 			{UploadID: 50, Flags: 3 | FlagAncestorVisible},
 			{UploadID: 51, Flags: 2},
-			{UploadID: 53, Flags: 0 | FlagAncestorVisible},
+			{UploadID: 53, Flags: 0 | FlagOverwritten | FlagAncestorVisible},
 		},
 		"i": {
+			{UploadID: 45, Flags: 2},
 			{UploadID: 50, Flags: 4 | FlagAncestorVisible},
-			{UploadID: 54, Flags: 0 | FlagAncestorVisible},
+			{UploadID: 54, Flags: 0 | FlagOverwritten | FlagAncestorVisible},
 		},
 		"h": {
 			{UploadID: 50, Flags: 4 | FlagAncestorVisible},
 			{UploadID: 51, Flags: 1},
-			{UploadID: 55, Flags: 0 | FlagAncestorVisible},
+			{UploadID: 55, Flags: 0 | FlagOverwritten | FlagAncestorVisible},
+			{UploadID: 56, Flags: 2},
 		},
 		"j": {
 			{UploadID: 50, Flags: 2 | FlagAncestorVisible},
@@ -109,7 +119,8 @@ func TestCalculateVisibleUploads(t *testing.T) {
 		},
 		"k": {
 			{UploadID: 50, Flags: 5 | FlagAncestorVisible},
-			{UploadID: 55, Flags: 1 | FlagAncestorVisible},
+			{UploadID: 55, Flags: 1 | FlagOverwritten | FlagAncestorVisible},
+			{UploadID: 56, Flags: 1},
 		},
 		"m": {
 			{UploadID: 50, Flags: 6 | FlagAncestorVisible},
@@ -282,6 +293,232 @@ func TestCalculateVisibleUploadsAlternateCommitGraph(t *testing.T) {
 	}
 }
 
+func TestGenerationOf(t *testing.T) {
+	// testGraph has the following layout:
+	//
+	//   [a] -- b -- c
+	//
+	testGraph := gitserver.ParseCommitGraph([]string{
+		"c b",
+		"b a",
+	})
+
+	graph := NewGraph(testGraph, NewCommitGraphView())
+
+	for _, testCase := range []struct {
+		commit    string
+		wantLevel uint32
+		wantOk    bool
+	}{
+		{"a", 1, true},
+		{"b", 2, true},
+		{"c", 3, true},
+		{"z", 0, false},
+	} {
+		level, _, ok := graph.GenerationOf(testCase.commit)
+		if ok != testCase.wantOk {
+			t.Fatalf("unexpected ok for commit %q: want=%v have=%v", testCase.commit, testCase.wantOk, ok)
+		}
+		if ok && level != testCase.wantLevel {
+			t.Errorf("unexpected generation level for commit %q: want=%d have=%d", testCase.commit, testCase.wantLevel, level)
+		}
+	}
+}
+
+// TestTraverseForCommitStopsOnInconsistentGenerations exercises the early-return guard in
+// traverseForCommit directly, since a well-formed generations map never triggers it (see that
+// function's doc comment): it can only fire on a generations map that disagrees with the graph
+// it's paired with, which this test constructs by hand rather than reaching for through NewGraph.
+func TestTraverseForCommitStopsOnInconsistentGenerations(t *testing.T) {
+	// e -- d -- c -- b -- a, parent edges right to left; only "a" carries an upload.
+	graph := map[string][]string{
+		"e": {"d"},
+		"d": {"c"},
+		"c": {"b"},
+		"b": {"a"},
+		"a": nil,
+	}
+	uploads := map[string]map[string]UploadMeta{
+		"a": {"sub/:lsif-go": {UploadID: 1}},
+	}
+
+	t.Run("consistent generations reach the upload", func(t *testing.T) {
+		generations := map[string]generation{
+			"e": {level: 5}, "d": {level: 4}, "c": {level: 3}, "b": {level: 2}, "a": {level: 1},
+		}
+
+		commit, distance, ok := traverseForCommit(graph, uploads, generations, "e")
+		if !ok || commit != "a" || distance != 4 {
+			t.Fatalf("expected to reach \"a\" at distance 4, got commit=%q distance=%d ok=%v", commit, distance, ok)
+		}
+	})
+
+	t.Run("inconsistent generations stop the walk early", func(t *testing.T) {
+		// Levels dip below e's (5) at d, then climb back above it at c, which a
+		// consistently generated map could never do along a single-parent chain.
+		generations := map[string]generation{
+			"e": {level: 5}, "d": {level: 2}, "c": {level: 9}, "b": {level: 2}, "a": {level: 1},
+		}
+
+		commit, distance, ok := traverseForCommit(graph, uploads, generations, "e")
+		if ok {
+			t.Fatalf("expected the inconsistency between d and c's levels to stop the walk before reaching \"a\", got commit=%q distance=%d", commit, distance)
+		}
+	})
+}
+
+func TestRecurse(t *testing.T) {
+	// testGraph has the following layout:
+	//
+	//       +--- b -------------------------------+-- [j]
+	//       |                                     |
+	// [a] --+         +-- d             +-- [h] --+--- k -- [m]
+	//       |         |                 |
+	//       +-- [c] --+       +-- [f] --+
+	//                 |       |         |
+	//                 +-- e --+         +-- [i] ------ l -- [n]
+	//                         |
+	//                         +--- g
+	//
+	testGraph := gitserver.ParseCommitGraph([]string{
+		"n l",
+		"m k",
+		"k h",
+		"j b h",
+		"h f",
+		"l i",
+		"i f",
+		"f e",
+		"g e",
+		"e c",
+		"d c",
+		"c a",
+		"b a",
+	})
+
+	commitGraphView := NewCommitGraphView()
+	commitGraphView.Add(UploadMeta{UploadID: 50}, "a", "sub1/:lsif-go")
+	commitGraphView.Add(UploadMeta{UploadID: 55}, "h", "sub3/:lsif-go")
+
+	graph := NewGraph(testGraph, commitGraphView)
+
+	t.Run("visits every ancestor once", func(t *testing.T) {
+		var visited []string
+		if err := graph.Recurse("j", DirectionAncestors, func(commit string, _ []UploadMeta) error {
+			visited = append(visited, commit)
+			return nil
+		}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		sort.Strings(visited)
+		expected := []string{"a", "b", "c", "e", "f", "h", "j"}
+		if diff := cmp.Diff(expected, visited); diff != "" {
+			t.Errorf("unexpected set of visited commits (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("stops the current branch on ErrStopRecursing", func(t *testing.T) {
+		var visited []string
+		if err := graph.Recurse("n", DirectionAncestors, func(commit string, uploads []UploadMeta) error {
+			visited = append(visited, commit)
+			if commit == "i" {
+				return ErrStopRecursing
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		sort.Strings(visited)
+		expected := []string{"i", "l", "n"}
+		if diff := cmp.Diff(expected, visited); diff != "" {
+			t.Errorf("unexpected set of visited commits (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("propagates other errors", func(t *testing.T) {
+		boom := fmt.Errorf("boom")
+
+		err := graph.Recurse("j", DirectionAncestors, func(commit string, _ []UploadMeta) error {
+			if commit == "c" {
+				return boom
+			}
+			return nil
+		})
+		if err != boom {
+			t.Fatalf("unexpected error: want=%s have=%s", boom, err)
+		}
+	})
+}
+
+func TestStreamWithOptions(t *testing.T) {
+	// testGraph has the following (linear-ish) layout:
+	//
+	// [a] -- b -- [c] -- d
+	//
+	testGraph := gitserver.ParseCommitGraph([]string{
+		"d c",
+		"c b",
+		"b a",
+	})
+
+	commitGraphView := NewCommitGraphView()
+	commitGraphView.Add(UploadMeta{UploadID: 1}, "a", "sub1/:lsif-go")
+	commitGraphView.Add(UploadMeta{UploadID: 2}, "c", "sub1/:lsif-go")
+	commitGraphView.SetCommitDate("a", 100)
+	commitGraphView.SetCommitDate("b", 200)
+	commitGraphView.SetCommitDate("c", 300)
+	commitGraphView.SetCommitDate("d", 400)
+
+	graph := NewGraph(testGraph, commitGraphView)
+
+	collect := func(opts StreamOptions) []string {
+		var commits []string
+		for envelope := range graph.StreamWithOptions(opts) {
+			switch {
+			case envelope.Uploads != nil:
+				commits = append(commits, envelope.Uploads.Commit)
+			case envelope.Links != nil:
+				commits = append(commits, envelope.Links.Commit)
+			}
+		}
+		return commits
+	}
+
+	t.Run("topological", func(t *testing.T) {
+		if diff := cmp.Diff([]string{"d", "c", "b", "a"}, collect(StreamOptions{Order: OrderTopological})); diff != "" {
+			t.Errorf("unexpected commit order (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("topological reverse", func(t *testing.T) {
+		if diff := cmp.Diff([]string{"a", "b", "c", "d"}, collect(StreamOptions{Order: OrderTopological, Reverse: true})); diff != "" {
+			t.Errorf("unexpected commit order (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("commit date, newest first", func(t *testing.T) {
+		if diff := cmp.Diff([]string{"d", "c", "b", "a"}, collect(StreamOptions{Order: OrderCommitDate})); diff != "" {
+			t.Errorf("unexpected commit order (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("commit date, since filters older commits", func(t *testing.T) {
+		opts := StreamOptions{Order: OrderCommitDate, Since: time.Unix(250, 0)}
+		if diff := cmp.Diff([]string{"d", "c"}, collect(opts)); diff != "" {
+			t.Errorf("unexpected commit order (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("limit caps the number of envelopes", func(t *testing.T) {
+		opts := StreamOptions{Order: OrderCommitDate, Limit: 2}
+		if diff := cmp.Diff([]string{"d", "c"}, collect(opts)); diff != "" {
+			t.Errorf("unexpected commit order (-want +got):\n%s", diff)
+		}
+	})
+}
+
 func TestReverseGraph(t *testing.T) {
 	reverseGraph := reverseGraph(map[string][]string{
 		"a": {"b", "c"},
@@ -332,6 +569,34 @@ func BenchmarkCalculateVisibleUploads(b *testing.B) {
 	fmt.Printf("\nNum Uploads: %d\nNum Links:   %d\n\n", numUploads, len(links))
 }
 
+// BenchmarkNewGraphAppendingTip measures the cost of a full NewGraph/Gather pass over a linear
+// history as it grows, to quantify the savings dbstore.Store.CalculateVisibleUploadsIncremental
+// is after: it writes back only the rows for new commits and for commits whose visibility
+// changed (though it still reads every stored row to find the latter), while this
+// benchmark's in-memory cost (which it still pays, since commitgraph has no way to update a
+// Graph in place) grows with the size of the whole history.
+func BenchmarkNewGraphAppendingTip(b *testing.B) {
+	const numCommits = 10000
+
+	lines := make([]string, 0, numCommits)
+	for i := 1; i < numCommits; i++ {
+		lines = append(lines, fmt.Sprintf("c%d c%d", i, i-1))
+	}
+	testGraph := gitserver.ParseCommitGraph(lines)
+
+	commitGraphView := NewCommitGraphView()
+	for i := 0; i < numCommits; i += 7 {
+		commitGraphView.Add(UploadMeta{UploadID: i}, fmt.Sprintf("c%d", i), "sub/:lsif-go")
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		NewGraph(testGraph, commitGraphView).Gather()
+	}
+}
+
 func readBenchmarkCommitGraph() (*gitserver.CommitGraph, error) {
 	contents, err := readBenchmarkFile("./testdata/commits.txt.gz")
 	if err != nil {
@@ -0,0 +1,51 @@
+package commitgraph
+
+// CommitGraphView is a space-efficient view of the data required to determine visible uploads
+// for each commit in a commit graph.
+type CommitGraphView struct {
+	Meta   map[string][]UploadMeta
+	Tokens map[int]string
+
+	// CommitDates holds the committer timestamp (as a Unix epoch) for each commit that defines
+	// an upload, when known. It is consulted by NewGraph to compute a "corrected commit date"
+	// (generation number v2) for each upload's source commit, and by
+	// Graph.StreamWithOptions(StreamOptions{Order: OrderCommitDate}). Callers that do not have
+	// access to commit timestamps may leave this empty, in which case only the
+	// topological-level generation number (v1) is used to order uploads.
+	CommitDates map[string]int64
+
+	// AuthorDates holds the author timestamp (as a Unix epoch) for each commit, when known.
+	// It is only consulted by Graph.StreamWithOptions(StreamOptions{Order: OrderAuthorDate}).
+	AuthorDates map[string]int64
+}
+
+// NewCommitGraphView creates an empty CommitGraphView.
+func NewCommitGraphView() *CommitGraphView {
+	return &CommitGraphView{
+		Meta:        map[string][]UploadMeta{},
+		Tokens:      map[int]string{},
+		CommitDates: map[string]int64{},
+		AuthorDates: map[string]int64{},
+	}
+}
+
+// Add associates the given upload metadata with the given commit, and records the token
+// (a value unique to the upload's root and indexer) by which it can be correlated with
+// other uploads providing overlapping coverage.
+func (c *CommitGraphView) Add(meta UploadMeta, commit, token string) {
+	c.Meta[commit] = append(c.Meta[commit], meta)
+	c.Tokens[meta.UploadID] = token
+}
+
+// SetCommitDate records the committer timestamp for the given commit. Supplying commit dates
+// enables generation number v2 (corrected commit date) tie-breaking in NewGraph, as well as
+// OrderCommitDate streaming.
+func (c *CommitGraphView) SetCommitDate(commit string, timestamp int64) {
+	c.CommitDates[commit] = timestamp
+}
+
+// SetAuthorDate records the author timestamp for the given commit, enabling OrderAuthorDate
+// streaming.
+func (c *CommitGraphView) SetAuthorDate(commit string, timestamp int64) {
+	c.AuthorDates[commit] = timestamp
+}
@@ -0,0 +1,93 @@
+package commitgraph
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// countingWriter wraps an io.Writer and tracks the total number of bytes written through it,
+// so that WriteTo can report its return value without every caller having to sum up each
+// individual Write.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func writeExact(w io.Writer, p []byte) error {
+	_, err := w.Write(p)
+	return err
+}
+
+func readExact(r io.Reader, p []byte) error {
+	_, err := io.ReadFull(r, p)
+	return err
+}
+
+// encoder is a minimal append-only byte buffer with big-endian fixed-width and
+// length-prefixed-string helpers, used to build up commitgraph cache chunk payloads.
+type encoder struct {
+	buf []byte
+}
+
+func newEncoder() *encoder {
+	return &encoder{}
+}
+
+func (e *encoder) putUint32(v uint32) {
+	var tmp [4]byte
+	binary.BigEndian.PutUint32(tmp[:], v)
+	e.buf = append(e.buf, tmp[:]...)
+}
+
+func (e *encoder) putBytes(p []byte) {
+	e.buf = append(e.buf, p...)
+}
+
+func (e *encoder) putString(s string) {
+	e.putUint32(uint32(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) bytes() []byte {
+	return e.buf
+}
+
+// decoder reads values back out of a chunk payload produced by encoder, in the same order
+// they were written.
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+func newDecoder(buf []byte) *decoder {
+	return &decoder{buf: buf}
+}
+
+func (d *decoder) getUint32() (uint32, error) {
+	if d.pos+4 > len(d.buf) {
+		return 0, fmt.Errorf("commitgraph: unexpected end of chunk payload")
+	}
+	v := binary.BigEndian.Uint32(d.buf[d.pos : d.pos+4])
+	d.pos += 4
+	return v, nil
+}
+
+func (d *decoder) getString() (string, error) {
+	n, err := d.getUint32()
+	if err != nil {
+		return "", err
+	}
+	if d.pos+int(n) > len(d.buf) {
+		return "", fmt.Errorf("commitgraph: unexpected end of chunk payload")
+	}
+	s := string(d.buf[d.pos : d.pos+int(n)])
+	d.pos += int(n)
+	return s, nil
+}
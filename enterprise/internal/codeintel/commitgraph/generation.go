@@ -0,0 +1,48 @@
+package commitgraph
+
+// generation holds the two commit-graph generation numbers computed for a single commit.
+type generation struct {
+	// level is generation number v1: one plus the maximum level of the commit's parents,
+	// or one for a root commit. It is a pure function of the graph's topology.
+	level uint32
+
+	// correctedCommitDate is generation number v2: the maximum of the commit's own
+	// timestamp and one plus the maximum corrected commit date of its parents. It
+	// requires commit timestamps and is left at zero when none are supplied.
+	correctedCommitDate uint32
+}
+
+// computeGenerations calculates, for every commit in the graph, the topological level and
+// (when commit timestamps are available on commitGraphView) the corrected commit date. The
+// order slice must list commits in an order where each commit appears after all of its
+// parents (i.e. ancestors-first topological order).
+func computeGenerations(graph map[string][]string, order []string, commitGraphView *CommitGraphView) map[string]generation {
+	generations := make(map[string]generation, len(order))
+	haveCommitDates := len(commitGraphView.CommitDates) != 0
+
+	for _, commit := range order {
+		var level uint32 = 1
+		var ccd uint32
+		if haveCommitDates {
+			if timestamp := commitGraphView.CommitDates[commit]; timestamp > 0 {
+				ccd = uint32(timestamp)
+			}
+		}
+
+		for _, parent := range graph[commit] {
+			parentGeneration := generations[parent]
+
+			if parentGeneration.level+1 > level {
+				level = parentGeneration.level + 1
+			}
+
+			if haveCommitDates && parentGeneration.correctedCommitDate+1 > ccd {
+				ccd = parentGeneration.correctedCommitDate + 1
+			}
+		}
+
+		generations[commit] = generation{level: level, correctedCommitDate: ccd}
+	}
+
+	return generations
+}
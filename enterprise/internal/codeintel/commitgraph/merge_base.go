@@ -0,0 +1,71 @@
+package commitgraph
+
+// MergeBase returns the nearest common ancestor of commitA and commitB, along with a boolean
+// indicating whether one was found. When the two commits have more than one lowest common
+// ancestor (a criss-cross merge history), one of them is returned; which one is unspecified.
+//
+// The search expands two frontiers, one rooted at each commit, walking parent edges one
+// generation at a time. At each step the frontier with the higher maximum generation level is
+// the one expanded, mirroring git's "paint down to common ancestor" approach: since a commit's
+// generation level is always higher than every one of its ancestors', expanding the higher
+// frontier first guarantees neither side is walked past the point where the other could still
+// catch up, while keeping the total number of visited commits small.
+func (g *Graph) MergeBase(commitA, commitB string) (string, bool) {
+	if commitA == commitB {
+		return commitA, true
+	}
+
+	if _, ok := g.generations[commitA]; !ok {
+		return "", false
+	}
+	if _, ok := g.generations[commitB]; !ok {
+		return "", false
+	}
+
+	frontierA := []string{commitA}
+	frontierB := []string{commitB}
+	visitedA := map[string]bool{commitA: true}
+	visitedB := map[string]bool{commitB: true}
+
+	for len(frontierA) != 0 || len(frontierB) != 0 {
+		var expanding *[]string
+		var visiting, other map[string]bool
+
+		if g.maxGenerationLevel(frontierB) > g.maxGenerationLevel(frontierA) {
+			expanding, visiting, other = &frontierB, visitedB, visitedA
+		} else {
+			expanding, visiting, other = &frontierA, visitedA, visitedB
+		}
+
+		frontier := *expanding
+		*expanding = nil
+
+		for _, commit := range frontier {
+			if other[commit] {
+				return commit, true
+			}
+
+			for _, parent := range g.graph[commit] {
+				if !visiting[parent] {
+					visiting[parent] = true
+					*expanding = append(*expanding, parent)
+				}
+			}
+		}
+	}
+
+	return "", false
+}
+
+// maxGenerationLevel returns the highest generation level (v1) among the given commits, or
+// zero if commits is empty.
+func (g *Graph) maxGenerationLevel(commits []string) uint32 {
+	var max uint32
+	for _, commit := range commits {
+		if level := g.generations[commit].level; level > max {
+			max = level
+		}
+	}
+
+	return max
+}
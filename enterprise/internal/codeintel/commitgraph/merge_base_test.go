@@ -0,0 +1,77 @@
+package commitgraph
+
+import (
+	"testing"
+
+	"github.com/sourcegraph/sourcegraph/enterprise/internal/codeintel/gitserver"
+)
+
+func TestMergeBaseDiamond(t *testing.T) {
+	// testGraph has the following layout:
+	//
+	// a --+-- b --+-- d
+	//     +-- c --+
+	graph := NewGraph(gitserver.ParseCommitGraph([]string{
+		"d b c",
+		"b a",
+		"c a",
+	}), NewCommitGraphView())
+
+	mergeBase, ok := graph.MergeBase("b", "c")
+	if !ok {
+		t.Fatalf("expected a merge base to be found")
+	}
+	if mergeBase != "a" {
+		t.Errorf("unexpected merge base. want=%q have=%q", "a", mergeBase)
+	}
+}
+
+func TestMergeBaseAncestor(t *testing.T) {
+	graph := NewGraph(gitserver.ParseCommitGraph([]string{
+		"c b",
+		"b a",
+	}), NewCommitGraphView())
+
+	mergeBase, ok := graph.MergeBase("a", "c")
+	if !ok {
+		t.Fatalf("expected a merge base to be found")
+	}
+	if mergeBase != "a" {
+		t.Errorf("unexpected merge base. want=%q have=%q", "a", mergeBase)
+	}
+}
+
+func TestMergeBaseCrissCross(t *testing.T) {
+	// testGraph has the following layout:
+	//
+	// a1 --+-- b1
+	//      |
+	//      +-- b2
+	//
+	// a2 --+-- b1
+	//      |
+	//      +-- b2
+	graph := NewGraph(gitserver.ParseCommitGraph([]string{
+		"b1 a1 a2",
+		"b2 a1 a2",
+	}), NewCommitGraphView())
+
+	mergeBase, ok := graph.MergeBase("b1", "b2")
+	if !ok {
+		t.Fatalf("expected a merge base to be found")
+	}
+	if mergeBase != "a1" && mergeBase != "a2" {
+		t.Errorf("unexpected merge base. want one of %q or %q, have=%q", "a1", "a2", mergeBase)
+	}
+}
+
+func TestMergeBaseUnrelated(t *testing.T) {
+	graph := NewGraph(gitserver.ParseCommitGraph([]string{
+		"a",
+		"b",
+	}), NewCommitGraphView())
+
+	if _, ok := graph.MergeBase("a", "b"); ok {
+		t.Fatalf("expected no merge base to be found for unrelated histories")
+	}
+}
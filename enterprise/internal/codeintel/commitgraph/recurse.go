@@ -0,0 +1,61 @@
+package commitgraph
+
+import "errors"
+
+// Direction indicates which way a Recurse call walks the commit graph from its starting
+// commit.
+type Direction int
+
+const (
+	DirectionAncestors Direction = iota
+	DirectionDescendants
+)
+
+// ErrStopRecursing is a sentinel error a Recurse callback can return to stop descending into
+// the branch currently being explored without aborting the walk of any other pending branch.
+// Any other non-nil error returned by the callback aborts the entire walk and is propagated
+// out of Recurse.
+var ErrStopRecursing = errors.New("commitgraph: stop recursing this branch")
+
+// Recurse performs a depth-first walk of the commit graph starting at startCommit in the given
+// direction, invoking fn once per visited commit with the combined set of uploads visible from
+// that commit (the same value UploadsVisibleAtCommit would return). A shared set of visited
+// commits is tracked for the whole walk so that a commit reachable through more than one branch
+// of a diamond history is not processed more than once.
+//
+// This is useful for bounded queries such as "walk ancestors of HEAD until the first commit with
+// an upload for root X is found" without materializing the full visibility map the way Gather
+// does.
+func (g *Graph) Recurse(startCommit string, direction Direction, fn func(commit string, uploads []UploadMeta) error) error {
+	graph := g.graph
+	if direction == DirectionDescendants {
+		graph = g.reverseGraph
+	}
+
+	return g.recurse(graph, startCommit, fn, map[string]struct{}{})
+}
+
+// recurse is the unexported worker behind Recurse. It is called once per DFS branch and
+// returns nil (without visiting any descendants) when fn returns ErrStopRecursing.
+func (g *Graph) recurse(graph map[string][]string, commit string, fn func(commit string, uploads []UploadMeta) error, visited map[string]struct{}) error {
+	if _, ok := visited[commit]; ok {
+		return nil
+	}
+	visited[commit] = struct{}{}
+
+	if err := fn(commit, g.UploadsVisibleAtCommit(commit)); err != nil {
+		if err == ErrStopRecursing {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, next := range graph[commit] {
+		if err := g.recurse(graph, next, fn, visited); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,458 @@
+package commitgraph
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/sourcegraph/sourcegraph/enterprise/internal/codeintel/gitserver"
+)
+
+// fileMagic identifies a commitgraph cache file, borrowing the shape (if not the exact byte
+// layout) of Git's own commit-graph file: a magic header followed by a table of contents of
+// 4-byte chunk identifiers and 8-byte offsets, followed by the chunk payloads themselves.
+var fileMagic = [4]byte{'C', 'G', 'P', 'H'}
+
+const fileVersion = 1
+
+// Chunk identifiers, analogous to Git's OIDF/OIDL/BIDX/etc chunk IDs.
+var (
+	chunkCommits      = [4]byte{'C', 'O', 'M', 'M'} // sorted commit list
+	chunkGenerations  = [4]byte{'G', 'E', 'N', 'N'} // per-commit generation numbers
+	chunkSelected     = [4]byte{'S', 'E', 'L', 'B'} // bitmap of select commits (see populateUploadsByTraversal)
+	chunkAncestors    = [4]byte{'A', 'N', 'C', 'U'} // ancestorUploads, keyed by select commit
+	chunkDescendants  = [4]byte{'D', 'E', 'S', 'U'} // descendantUploads, keyed by select commit
+)
+
+// WriteTo serializes the decorated portion of the graph (the sorted commit list, the set of
+// select commits, their generation numbers, and the precomputed ancestor/descendant visibility
+// maps) to w in the chunk-based binary format described above. It does not serialize the raw
+// parent/child edges of the commit graph, since those are cheap to re-derive from gitserver and
+// callers already have them at hand whenever they load a cache back in with LoadGraph.
+func (g *Graph) WriteTo(w io.Writer) (int64, error) {
+	commits := make([]string, len(g.commits))
+	copy(commits, g.commits)
+	sort.Strings(commits)
+
+	chunks := []struct {
+		id      [4]byte
+		payload []byte
+	}{
+		{chunkCommits, encodeCommits(commits)},
+		{chunkSelected, encodeSelectedBitmap(commits, g.ancestorUploads, g.descendantUploads)},
+		{chunkGenerations, encodeGenerations(commits, g.generations)},
+		{chunkAncestors, encodeUploadMap(commits, g.ancestorUploads)},
+		{chunkDescendants, encodeUploadMap(commits, g.descendantUploads)},
+	}
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	if err := writeExact(cw, fileMagic[:]); err != nil {
+		return cw.n, err
+	}
+	if err := writeExact(cw, []byte{fileVersion}); err != nil {
+		return cw.n, err
+	}
+	if err := binary.Write(cw, binary.BigEndian, uint32(len(chunks))); err != nil {
+		return cw.n, err
+	}
+
+	// Table of contents: each entry is a 4-byte chunk ID plus the 8-byte offset (from the
+	// start of the file) at which that chunk's payload begins.
+	headerSize := int64(len(fileMagic)) + 1 + 4 + int64(len(chunks))*12
+	offset := headerSize
+	for _, chunk := range chunks {
+		if err := writeExact(cw, chunk.id[:]); err != nil {
+			return cw.n, err
+		}
+		if err := binary.Write(cw, binary.BigEndian, uint64(offset)); err != nil {
+			return cw.n, err
+		}
+		offset += int64(len(chunk.payload))
+	}
+
+	for _, chunk := range chunks {
+		if err := writeExact(cw, chunk.payload); err != nil {
+			return cw.n, err
+		}
+	}
+
+	if bw, ok := cw.w.(*bufio.Writer); ok {
+		if err := bw.Flush(); err != nil {
+			return cw.n, err
+		}
+	}
+
+	return cw.n, nil
+}
+
+// LoadGraph deserializes a Graph previously written by WriteTo. The commit graph's parent and
+// child edges are taken from commitGraph (the same value that would otherwise be passed to
+// NewGraph) rather than from the cache file, as they are cheap to recompute and this keeps the
+// on-disk format from duplicating data gitserver already gives us for free. commitGraphView is
+// retained on the returned Graph for parity with NewGraph, but is not otherwise consulted: all
+// of the data it would normally be used to derive is already present in the cache file.
+func LoadGraph(r io.Reader, commitGraph *gitserver.CommitGraph, commitGraphView *CommitGraphView) (*Graph, error) {
+	br := bufio.NewReader(r)
+
+	var magic [4]byte
+	if err := readExact(br, magic[:]); err != nil {
+		return nil, err
+	}
+	if magic != fileMagic {
+		return nil, fmt.Errorf("commitgraph: invalid file magic %q", magic)
+	}
+
+	var version [1]byte
+	if err := readExact(br, version[:]); err != nil {
+		return nil, err
+	}
+	if version[0] != fileVersion {
+		return nil, fmt.Errorf("commitgraph: unsupported file version %d", version[0])
+	}
+
+	var numChunks uint32
+	if err := binary.Read(br, binary.BigEndian, &numChunks); err != nil {
+		return nil, err
+	}
+
+	type tocEntry struct {
+		id     [4]byte
+		offset uint64
+	}
+	toc := make([]tocEntry, numChunks)
+	for i := range toc {
+		if err := readExact(br, toc[i].id[:]); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(br, binary.BigEndian, &toc[i].offset); err != nil {
+			return nil, err
+		}
+	}
+
+	// The remainder of the reader holds the chunk payloads back-to-back, in the same order
+	// they were written; we don't need random access, so a buffered sequential read over the
+	// rest of the stream is sufficient (and, unlike Git's own reader, doesn't require r to be
+	// seekable).
+	rest, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+
+	// Chunk payloads are laid out contiguously in TOC order starting at toc[0].offset, so
+	// each chunk's end is simply the next chunk's start (or the end of the stream for the
+	// last one).
+	base := uint64(0)
+	if len(toc) > 0 {
+		base = toc[0].offset
+	}
+
+	chunkByID := make(map[[4]byte][]byte, len(toc))
+	for i, entry := range toc {
+		end := base + uint64(len(rest))
+		if i+1 < len(toc) {
+			end = toc[i+1].offset
+		}
+
+		chunkByID[entry.id] = rest[entry.offset-base : end-base]
+	}
+
+	commits, err := decodeCommits(chunkByID[chunkCommits])
+	if err != nil {
+		return nil, err
+	}
+
+	generations, err := decodeGenerations(commits, chunkByID[chunkGenerations])
+	if err != nil {
+		return nil, err
+	}
+
+	ancestorUploads, err := decodeUploadMap(commits, chunkByID[chunkAncestors])
+	if err != nil {
+		return nil, err
+	}
+
+	descendantUploads, err := decodeUploadMap(commits, chunkByID[chunkDescendants])
+	if err != nil {
+		return nil, err
+	}
+
+	graph := commitGraph.Graph()
+
+	return &Graph{
+		commitGraphView:   commitGraphView,
+		graph:             graph,
+		reverseGraph:      reverseGraph(graph),
+		commits:           commits,
+		ancestorUploads:   ancestorUploads,
+		descendantUploads: descendantUploads,
+		generations:       generations,
+	}, nil
+}
+
+// WriteDeltaTo serializes only the commits present in g but not in base, using the same chunk
+// format as WriteTo. This lets a re-index after a small push or upload batch write a file
+// proportional to what changed rather than to the size of the whole repository; the result is
+// meant to be read back with LoadGraphDelta against the same base.
+func (g *Graph) WriteDeltaTo(w io.Writer, base *Graph) (int64, error) {
+	baseCommits := make(map[string]struct{}, len(base.commits))
+	for _, commit := range base.commits {
+		baseCommits[commit] = struct{}{}
+	}
+
+	delta := &Graph{
+		ancestorUploads:   map[string]map[string]UploadMeta{},
+		descendantUploads: map[string]map[string]UploadMeta{},
+		generations:       map[string]generation{},
+	}
+	for _, commit := range g.commits {
+		if _, ok := baseCommits[commit]; ok {
+			continue
+		}
+
+		delta.commits = append(delta.commits, commit)
+		if byToken, ok := g.ancestorUploads[commit]; ok {
+			delta.ancestorUploads[commit] = byToken
+		}
+		if byToken, ok := g.descendantUploads[commit]; ok {
+			delta.descendantUploads[commit] = byToken
+		}
+		if gen, ok := g.generations[commit]; ok {
+			delta.generations[commit] = gen
+		}
+	}
+
+	return delta.WriteTo(w)
+}
+
+// LoadGraphDelta reads a delta file written by WriteDeltaTo and layers it on top of base,
+// returning a new Graph that combines base's data with the delta's. base itself is not
+// modified, so the same base may be used to load several deltas (e.g. one per incremental
+// re-index since the base was written).
+func LoadGraphDelta(r io.Reader, base *Graph, commitGraph *gitserver.CommitGraph, commitGraphView *CommitGraphView) (*Graph, error) {
+	delta, err := LoadGraph(r, commitGraph, commitGraphView)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := &Graph{
+		commitGraphView:   commitGraphView,
+		graph:             delta.graph,
+		reverseGraph:      delta.reverseGraph,
+		commits:           append(append([]string{}, base.commits...), delta.commits...),
+		ancestorUploads:   make(map[string]map[string]UploadMeta, len(base.ancestorUploads)+len(delta.ancestorUploads)),
+		descendantUploads: make(map[string]map[string]UploadMeta, len(base.descendantUploads)+len(delta.descendantUploads)),
+		generations:       make(map[string]generation, len(base.generations)+len(delta.generations)),
+	}
+
+	for commit, byToken := range base.ancestorUploads {
+		merged.ancestorUploads[commit] = byToken
+	}
+	for commit, byToken := range delta.ancestorUploads {
+		merged.ancestorUploads[commit] = byToken
+	}
+	for commit, byToken := range base.descendantUploads {
+		merged.descendantUploads[commit] = byToken
+	}
+	for commit, byToken := range delta.descendantUploads {
+		merged.descendantUploads[commit] = byToken
+	}
+	for commit, gen := range base.generations {
+		merged.generations[commit] = gen
+	}
+	for commit, gen := range delta.generations {
+		merged.generations[commit] = gen
+	}
+
+	sort.Strings(merged.commits)
+
+	return merged, nil
+}
+
+//
+// chunk encode/decode helpers
+//
+
+func encodeCommits(commits []string) []byte {
+	buf := newEncoder()
+	buf.putUint32(uint32(len(commits)))
+	for _, commit := range commits {
+		buf.putString(commit)
+	}
+	return buf.bytes()
+}
+
+func decodeCommits(payload []byte) ([]string, error) {
+	dec := newDecoder(payload)
+	n, err := dec.getUint32()
+	if err != nil {
+		return nil, err
+	}
+
+	commits := make([]string, 0, n)
+	for i := uint32(0); i < n; i++ {
+		commit, err := dec.getString()
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, commit)
+	}
+
+	return commits, nil
+}
+
+// encodeSelectedBitmap writes one bit per commit (in the same order as the commit chunk)
+// indicating whether that commit was chosen to carry precomputed upload data (see the
+// properties documented on populateUploadsByTraversal). It is not required to reconstruct the
+// graph -- decodeUploadMap can tell which commits are selected directly from the upload chunks
+// -- but is kept around (as it is in Git's own commit-graph file) for tooling that wants to
+// inspect the graph's shape without paying for the full upload payloads.
+func encodeSelectedBitmap(commits []string, ancestorUploads, descendantUploads map[string]map[string]UploadMeta) []byte {
+	bitmap := make([]byte, (len(commits)+7)/8)
+	for i, commit := range commits {
+		_, inAncestors := ancestorUploads[commit]
+		_, inDescendants := descendantUploads[commit]
+		if inAncestors || inDescendants {
+			bitmap[i/8] |= 1 << uint(i%8)
+		}
+	}
+
+	buf := newEncoder()
+	buf.putUint32(uint32(len(bitmap)))
+	buf.putBytes(bitmap)
+	return buf.bytes()
+}
+
+func encodeGenerations(commits []string, generations map[string]generation) []byte {
+	buf := newEncoder()
+	buf.putUint32(uint32(len(commits)))
+	for _, commit := range commits {
+		gen := generations[commit]
+		buf.putUint32(gen.level)
+		buf.putUint32(gen.correctedCommitDate)
+	}
+	return buf.bytes()
+}
+
+func decodeGenerations(commits []string, payload []byte) (map[string]generation, error) {
+	dec := newDecoder(payload)
+	n, err := dec.getUint32()
+	if err != nil {
+		return nil, err
+	}
+	if int(n) != len(commits) {
+		return nil, fmt.Errorf("commitgraph: generation chunk has %d entries, expected %d", n, len(commits))
+	}
+
+	generations := make(map[string]generation, n)
+	for _, commit := range commits {
+		level, err := dec.getUint32()
+		if err != nil {
+			return nil, err
+		}
+		ccd, err := dec.getUint32()
+		if err != nil {
+			return nil, err
+		}
+		generations[commit] = generation{level: level, correctedCommitDate: ccd}
+	}
+
+	return generations, nil
+}
+
+func encodeUploadMap(commits []string, uploads map[string]map[string]UploadMeta) []byte {
+	buf := newEncoder()
+
+	var selected []string
+	for _, commit := range commits {
+		if _, ok := uploads[commit]; ok {
+			selected = append(selected, commit)
+		}
+	}
+
+	buf.putUint32(uint32(len(selected)))
+	for _, commit := range selected {
+		buf.putString(commit)
+
+		byToken := uploads[commit]
+		buf.putUint32(uint32(len(byToken)))
+
+		// Sort tokens for deterministic output, matching the style used elsewhere in this
+		// package (e.g. Graph.commits) of sorting anything whose order would otherwise be
+		// nondeterministic because it came out of a map.
+		tokens := make([]string, 0, len(byToken))
+		for token := range byToken {
+			tokens = append(tokens, token)
+		}
+		sort.Strings(tokens)
+
+		for _, token := range tokens {
+			meta := byToken[token]
+			buf.putString(token)
+			buf.putUint32(uint32(meta.UploadID))
+			buf.putUint32(meta.Flags)
+			buf.putUint32(meta.GenerationLevel)
+			buf.putUint32(meta.CorrectedCommitDate)
+		}
+	}
+
+	return buf.bytes()
+}
+
+func decodeUploadMap(commits []string, payload []byte) (map[string]map[string]UploadMeta, error) {
+	dec := newDecoder(payload)
+	numCommits, err := dec.getUint32()
+	if err != nil {
+		return nil, err
+	}
+
+	uploads := make(map[string]map[string]UploadMeta, numCommits)
+	for i := uint32(0); i < numCommits; i++ {
+		commit, err := dec.getString()
+		if err != nil {
+			return nil, err
+		}
+
+		numTokens, err := dec.getUint32()
+		if err != nil {
+			return nil, err
+		}
+
+		byToken := make(map[string]UploadMeta, numTokens)
+		for j := uint32(0); j < numTokens; j++ {
+			token, err := dec.getString()
+			if err != nil {
+				return nil, err
+			}
+			uploadID, err := dec.getUint32()
+			if err != nil {
+				return nil, err
+			}
+			flags, err := dec.getUint32()
+			if err != nil {
+				return nil, err
+			}
+			level, err := dec.getUint32()
+			if err != nil {
+				return nil, err
+			}
+			ccd, err := dec.getUint32()
+			if err != nil {
+				return nil, err
+			}
+
+			byToken[token] = UploadMeta{
+				UploadID:            int(uploadID),
+				Flags:               flags,
+				GenerationLevel:     level,
+				CorrectedCommitDate: ccd,
+			}
+		}
+
+		uploads[commit] = byToken
+	}
+
+	return uploads, nil
+}
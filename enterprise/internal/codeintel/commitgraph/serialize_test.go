@@ -0,0 +1,97 @@
+package commitgraph
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sourcegraph/sourcegraph/enterprise/internal/codeintel/gitserver"
+)
+
+func TestWriteToAndLoadGraph(t *testing.T) {
+	testGraph := gitserver.ParseCommitGraph([]string{
+		"e c d",
+		"d b",
+		"c b",
+		"b a",
+	})
+
+	commitGraphView := NewCommitGraphView()
+	commitGraphView.Add(UploadMeta{UploadID: 1}, "a", "sub1/:lsif-go")
+	commitGraphView.Add(UploadMeta{UploadID: 2}, "c", "sub2/:lsif-go")
+	commitGraphView.Add(UploadMeta{UploadID: 3}, "d", "sub2/:lsif-go")
+
+	graph := NewGraph(testGraph, commitGraphView)
+
+	var buf bytes.Buffer
+	if _, err := graph.WriteTo(&buf); err != nil {
+		t.Fatalf("unexpected error writing graph: %s", err)
+	}
+
+	loaded, err := LoadGraph(&buf, testGraph, commitGraphView)
+	if err != nil {
+		t.Fatalf("unexpected error loading graph: %s", err)
+	}
+
+	for _, commit := range []string{"a", "b", "c", "d", "e"} {
+		want := graph.UploadsVisibleAtCommit(commit)
+		have := loaded.UploadsVisibleAtCommit(commit)
+
+		sortUploadMetas(want)
+		sortUploadMetas(have)
+
+		if diff := cmp.Diff(want, have); diff != "" {
+			t.Errorf("unexpected visible uploads for commit %q after round-trip (-want +got):\n%s", commit, diff)
+		}
+	}
+}
+
+func TestWriteDeltaToAndLoadGraphDelta(t *testing.T) {
+	baseGraph := gitserver.ParseCommitGraph([]string{
+		"c b",
+		"b a",
+	})
+	baseView := NewCommitGraphView()
+	baseView.Add(UploadMeta{UploadID: 1}, "a", "sub1/:lsif-go")
+	base := NewGraph(baseGraph, baseView)
+
+	fullGraph := gitserver.ParseCommitGraph([]string{
+		"e d",
+		"d c",
+		"c b",
+		"b a",
+	})
+	fullView := NewCommitGraphView()
+	fullView.Add(UploadMeta{UploadID: 1}, "a", "sub1/:lsif-go")
+	fullView.Add(UploadMeta{UploadID: 4}, "e", "sub2/:lsif-go")
+	full := NewGraph(fullGraph, fullView)
+
+	var buf bytes.Buffer
+	if _, err := full.WriteDeltaTo(&buf, base); err != nil {
+		t.Fatalf("unexpected error writing delta: %s", err)
+	}
+
+	merged, err := LoadGraphDelta(&buf, base, fullGraph, fullView)
+	if err != nil {
+		t.Fatalf("unexpected error loading delta: %s", err)
+	}
+
+	for _, commit := range []string{"a", "b", "c", "d", "e"} {
+		want := full.UploadsVisibleAtCommit(commit)
+		have := merged.UploadsVisibleAtCommit(commit)
+
+		sortUploadMetas(want)
+		sortUploadMetas(have)
+
+		if diff := cmp.Diff(want, have); diff != "" {
+			t.Errorf("unexpected visible uploads for commit %q after delta merge (-want +got):\n%s", commit, diff)
+		}
+	}
+}
+
+func sortUploadMetas(uploads []UploadMeta) {
+	sort.Slice(uploads, func(i, j int) bool {
+		return uploads[i].UploadID < uploads[j].UploadID
+	})
+}
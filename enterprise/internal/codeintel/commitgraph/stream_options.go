@@ -0,0 +1,253 @@
+package commitgraph
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Order selects how Graph.StreamWithOptions sequences the commits it emits.
+type Order int
+
+const (
+	// OrderTopological emits a commit only after all of its children have already been
+	// emitted (a reverse-topological, "git log"-like order). Commits that become emittable
+	// at the same time are broken by generation level (see Graph.GenerationOf), so that
+	// history merged more recently is emitted first.
+	OrderTopological Order = iota
+
+	// OrderCommitDate emits commits ordered by CommitGraphView.CommitDates, newest first.
+	OrderCommitDate
+
+	// OrderAuthorDate emits commits ordered by CommitGraphView.AuthorDates, newest first.
+	OrderAuthorDate
+)
+
+// StreamOptions configures the order, bounds, and size of Graph.StreamWithOptions' output.
+type StreamOptions struct {
+	// Order selects how commits are sequenced before being emitted.
+	Order Order
+
+	// Reverse flips the direction of Order: oldest first for the date orders, or ancestors
+	// before descendants for OrderTopological.
+	Reverse bool
+
+	// Since, when non-zero, drops any commit whose timestamp (per Order) is before it. It
+	// is ignored for OrderTopological, which is a structural rather than a time-based order.
+	Since time.Time
+
+	// Limit caps the number of envelopes emitted. Zero (the default) means unlimited.
+	Limit int
+}
+
+// StreamWithOptions behaves like Stream, but sequences its output according to opts instead
+// of an incidental sort.Strings(order) of the commit set.
+func (g *Graph) StreamWithOptions(opts StreamOptions) <-chan Envelope {
+	ch := make(chan Envelope)
+
+	go func() {
+		defer close(ch)
+
+		var commits []string
+		switch opts.Order {
+		case OrderCommitDate:
+			commits = g.orderByDate(g.commitGraphView.CommitDates, opts)
+		case OrderAuthorDate:
+			commits = g.orderByDate(g.commitGraphView.AuthorDates, opts)
+		default:
+			commits = g.orderTopologically(opts)
+		}
+
+		emitted := 0
+		for _, commit := range commits {
+			if opts.Limit > 0 && emitted >= opts.Limit {
+				return
+			}
+
+			if g.emitEnvelope(ch, commit) {
+				emitted++
+			}
+		}
+	}()
+
+	return ch
+}
+
+// emitEnvelope sends the envelope for the given commit (mirroring the logic in Stream) and
+// reports whether anything was sent.
+func (g *Graph) emitEnvelope(ch chan<- Envelope, commit string) bool {
+	ancestorCommit, ancestorDistance, found1 := traverseForCommit(g.graph, g.ancestorUploads, g.generations, commit)
+	descendantCommit, descendantDistance, found2 := traverseForCommit(g.reverseGraph, g.descendantUploads, g.generations, commit)
+	if !found1 && !found2 {
+		return false
+	}
+
+	ancestorVisibleUploads := g.ancestorUploads[ancestorCommit]
+	descendantVisibleUploads := g.descendantUploads[descendantCommit]
+	if len(ancestorVisibleUploads)+len(descendantVisibleUploads) == 0 {
+		return false
+	}
+
+	uploads := combineVisibleUploadsForCommit(
+		ancestorVisibleUploads,
+		descendantVisibleUploads,
+		ancestorDistance,
+		descendantDistance,
+	)
+
+	threshold := 1
+	if found1 && found2 {
+		threshold = 2
+	}
+
+	if (found1 && ancestorDistance == 0) || (found2 && descendantDistance == 0) || len(uploads) <= threshold {
+		ch <- Envelope{
+			Uploads: &VisibilityRelationship{
+				Commit:  commit,
+				Uploads: uploads,
+			},
+		}
+	} else {
+		// Otherwise, we have more than a pair of uploads. Because we also have a very cheap
+		// way of reconstructing this particular commit's visible uploads from its ancestors
+		// and descendants, we store that relationship instead, which is much smaller when
+		// the number of distinct LSIF roots becomes large.
+
+		ch <- Envelope{
+			Links: &LinkRelationship{
+				Commit:             commit,
+				Ancestor:           strPtrOk(ancestorCommit, found1),
+				AncestorDistance:   ancestorDistance,
+				Descendant:         strPtrOk(descendantCommit, found2),
+				DescendantDistance: descendantDistance,
+			},
+		}
+	}
+
+	return true
+}
+
+// orderTopologically performs a Kahn-style walk starting from the leaves of g.graph (the
+// commits with no children), releasing a commit once every one of its children has been
+// released. Ties between commits that become releasable at the same time are broken toward
+// the higher generation level, so that history merged more recently comes first.
+func (g *Graph) orderTopologically(opts StreamOptions) []string {
+	remainingChildren := make(map[string]int, len(g.commits))
+	for _, commit := range g.commits {
+		remainingChildren[commit] = len(g.reverseGraph[commit])
+	}
+
+	ready := &generationHeap{generations: g.generations}
+	for _, commit := range g.commits {
+		if remainingChildren[commit] == 0 {
+			heap.Push(ready, commit)
+		}
+	}
+
+	order := make([]string, 0, len(g.commits))
+	for ready.Len() > 0 {
+		commit := heap.Pop(ready).(string)
+		order = append(order, commit)
+
+		for _, parent := range g.graph[commit] {
+			remainingChildren[parent]--
+			if remainingChildren[parent] == 0 {
+				heap.Push(ready, parent)
+			}
+		}
+	}
+
+	if opts.Reverse {
+		for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+			order[i], order[j] = order[j], order[i]
+		}
+	}
+
+	return order
+}
+
+// orderByDate orders g.commits by the timestamps in dates (newest first, or oldest first if
+// opts.Reverse is set), dropping any commit older than opts.Since and any commit with no known
+// timestamp.
+func (g *Graph) orderByDate(dates map[string]int64, opts StreamOptions) []string {
+	h := &dateHeap{dates: dates, reverse: opts.Reverse}
+
+	since := opts.Since.Unix()
+	for _, commit := range g.commits {
+		timestamp, ok := dates[commit]
+		if !ok {
+			continue
+		}
+		if !opts.Since.IsZero() && timestamp < since {
+			continue
+		}
+
+		heap.Push(h, commit)
+	}
+
+	order := make([]string, 0, h.Len())
+	for h.Len() > 0 {
+		order = append(order, heap.Pop(h).(string))
+	}
+
+	return order
+}
+
+// generationHeap is a container/heap.Interface over commits that pops the commit with the
+// highest generation level first (ties broken lexicographically for determinism).
+type generationHeap struct {
+	commits     []string
+	generations map[string]generation
+}
+
+func (h *generationHeap) Len() int { return len(h.commits) }
+
+func (h *generationHeap) Less(i, j int) bool {
+	gi, gj := h.generations[h.commits[i]], h.generations[h.commits[j]]
+	if gi.level != gj.level {
+		return gi.level > gj.level
+	}
+	return h.commits[i] < h.commits[j]
+}
+
+func (h *generationHeap) Swap(i, j int) { h.commits[i], h.commits[j] = h.commits[j], h.commits[i] }
+
+func (h *generationHeap) Push(x interface{}) { h.commits = append(h.commits, x.(string)) }
+
+func (h *generationHeap) Pop() interface{} {
+	n := len(h.commits)
+	commit := h.commits[n-1]
+	h.commits = h.commits[:n-1]
+	return commit
+}
+
+// dateHeap is a container/heap.Interface over commits that pops the newest commit first (or
+// the oldest first, if reverse is set), ties broken lexicographically for determinism.
+type dateHeap struct {
+	commits []string
+	dates   map[string]int64
+	reverse bool
+}
+
+func (h *dateHeap) Len() int { return len(h.commits) }
+
+func (h *dateHeap) Less(i, j int) bool {
+	di, dj := h.dates[h.commits[i]], h.dates[h.commits[j]]
+	if di != dj {
+		if h.reverse {
+			return di < dj
+		}
+		return di > dj
+	}
+	return h.commits[i] < h.commits[j]
+}
+
+func (h *dateHeap) Swap(i, j int) { h.commits[i], h.commits[j] = h.commits[j], h.commits[i] }
+
+func (h *dateHeap) Push(x interface{}) { h.commits = append(h.commits, x.(string)) }
+
+func (h *dateHeap) Pop() interface{} {
+	n := len(h.commits)
+	commit := h.commits[n-1]
+	h.commits = h.commits[:n-1]
+	return commit
+}
@@ -2,8 +2,41 @@ package commitgraph
 
 // UploadMeta represents the visibility of an LSIF upload from a particular location
 // on a repository's commit graph. The Flags field describes the visibility of the
-// upload from the current viewer's perspective.
+// upload from the current viewer's perspective: the low MaxDistance bits hold the
+// BFS distance from the viewed commit to the nearest commit defining the upload, and
+// the high bits hold boolean markers (see FlagAncestorVisible and FlagOverwritten).
 type UploadMeta struct {
 	UploadID int
-	Distance uint32
+	Flags    uint32
+
+	// GenerationLevel is the commit-graph generation number (v1) of the commit that
+	// originally defined this upload: one plus the maximum generation level of that
+	// commit's parents, or one for a root commit. Unlike Flags, this value is fixed
+	// at the upload's source commit and is carried unchanged as the upload shadows
+	// across the rest of the graph, so it can be used to compare uploads defined on
+	// different branches without regard to how far each has traveled.
+	GenerationLevel uint32
+
+	// CorrectedCommitDate is the commit-graph generation number v2 ("corrected commit
+	// date") of the commit that originally defined this upload. It is computed from
+	// the committer timestamps supplied on CommitGraphView.CommitDates and is
+	// zero when no such timestamps were supplied.
+	CorrectedCommitDate uint32
 }
+
+const (
+	// MaxDistance is the maximum value that can be stored as a distance in the Flags
+	// field of an UploadMeta value. Any additional high bits are reserved for the
+	// flags below.
+	MaxDistance = uint32(1)<<24 - 1
+
+	// FlagAncestorVisible is set in an UploadMeta's Flags field when the upload was
+	// found to be visible from an ancestor of the commit being queried.
+	FlagAncestorVisible = uint32(1) << 24
+
+	// FlagOverwritten is set in an UploadMeta's Flags field when the upload it
+	// describes is shadowed by another upload with an equivalent root and indexer
+	// that replaces prefers over it: a higher generation level first, then a higher
+	// corrected commit date, then a smaller distance.
+	FlagOverwritten = uint32(1) << 25
+)
@@ -0,0 +1,96 @@
+package gitserver
+
+import "strings"
+
+// CommitGraph represents a repository's commit graph as a mapping from a commit to its direct
+// parents, along with an order over the commit set that is convenient for the topological
+// algorithms in the commitgraph package.
+type CommitGraph struct {
+	graph map[string][]string
+	order []string
+}
+
+// Graph returns a map from commit to its direct parent commits. Every commit known to the
+// graph, including roots with no parents, is present as a key (with a nil slice of parents).
+func (c *CommitGraph) Graph() map[string][]string {
+	return c.graph
+}
+
+// Order returns the known commits in ancestors-first topological order: a commit never
+// appears before any of its ancestors. This is the order commitgraph.NewGraph requires in
+// order to compute generation numbers and populate visibility data in a single pass.
+func (c *CommitGraph) Order() []string {
+	return c.order
+}
+
+// ParseCommitGraph converts the output of `git log --topo-order --format="%H %P"` (one line
+// per commit: the commit hash followed by zero or more parent hashes, in that order) into a
+// *CommitGraph.
+func ParseCommitGraph(lines []string) *CommitGraph {
+	graph := map[string][]string{}
+	var commits []string
+
+	addCommit := func(commit string) {
+		if _, ok := graph[commit]; !ok {
+			graph[commit] = nil
+			commits = append(commits, commit)
+		}
+	}
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		commit, parents := fields[0], fields[1:]
+		addCommit(commit)
+		graph[commit] = parents
+
+		for _, parent := range parents {
+			addCommit(parent)
+		}
+	}
+
+	return &CommitGraph{graph: graph, order: topoSortParentsFirst(graph, commits)}
+}
+
+// topoSortParentsFirst returns the keys of graph ordered so that every commit appears after
+// all of its parents, breaking ties toward the order commits were first referenced in
+// commits. It is the parents-first counterpart of the children-first Kahn's algorithm walk
+// commitgraph.Graph.orderTopologically performs over the already-built graph.
+func topoSortParentsFirst(graph map[string][]string, commits []string) []string {
+	remainingParents := make(map[string]int, len(commits))
+	children := make(map[string][]string, len(commits))
+	for _, commit := range commits {
+		remainingParents[commit] = len(graph[commit])
+	}
+	for commit, parents := range graph {
+		for _, parent := range parents {
+			children[parent] = append(children[parent], commit)
+		}
+	}
+
+	queue := make([]string, 0, len(commits))
+	for _, commit := range commits {
+		if remainingParents[commit] == 0 {
+			queue = append(queue, commit)
+		}
+	}
+
+	order := make([]string, 0, len(commits))
+	for len(queue) > 0 {
+		commit := queue[0]
+		queue = queue[1:]
+		order = append(order, commit)
+
+		for _, child := range children[commit] {
+			remainingParents[child]--
+			if remainingParents[child] == 0 {
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	return order
+}
@@ -0,0 +1,63 @@
+package gitserver
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseCommitGraph(t *testing.T) {
+	graph := ParseCommitGraph([]string{
+		"e c d",
+		"d b",
+		"c b",
+		"b a",
+	})
+
+	if diff := cmp.Diff(map[string][]string{
+		"a": nil,
+		"b": {"a"},
+		"c": {"b"},
+		"d": {"b"},
+		"e": {"c", "d"},
+	}, graph.Graph()); diff != "" {
+		t.Errorf("unexpected graph (-want +got):\n%s", diff)
+	}
+
+	assertParentsBeforeChildren(t, graph)
+}
+
+func TestParseCommitGraphOrphanedParent(t *testing.T) {
+	// "a" is never given its own line, only referenced as a parent, which happens for a root
+	// commit whenever the history supplied to ParseCommitGraph isn't rooted all the way back
+	// to the very first commit.
+	graph := ParseCommitGraph([]string{
+		"c b",
+		"b a",
+	})
+
+	if _, ok := graph.Graph()["a"]; !ok {
+		t.Fatalf("expected orphaned parent %q to be present in the graph", "a")
+	}
+
+	assertParentsBeforeChildren(t, graph)
+}
+
+// assertParentsBeforeChildren checks the invariant commitgraph.computeGenerations relies on:
+// every commit in graph.Order() appears after all of its parents.
+func assertParentsBeforeChildren(t *testing.T, graph *CommitGraph) {
+	t.Helper()
+
+	position := make(map[string]int, len(graph.Order()))
+	for i, commit := range graph.Order() {
+		position[commit] = i
+	}
+
+	for commit, parents := range graph.Graph() {
+		for _, parent := range parents {
+			if position[parent] >= position[commit] {
+				t.Errorf("parent %q does not precede child %q in Order()", parent, commit)
+			}
+		}
+	}
+}
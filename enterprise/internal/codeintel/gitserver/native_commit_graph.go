@@ -0,0 +1,180 @@
+package gitserver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// This file reads the commit-graph file format Git itself writes via `git commit-graph write`
+// (see Documentation/technical/commit-graph-format in git's own source tree): a magic header,
+// a table of contents of 4-byte chunk identifiers and 8-byte offsets, followed by the chunk
+// payloads themselves. It is a read-only, subset implementation: we only decode the chunks
+// needed to recover parent/child edges (OIDL, CDAT, and the overflow parent list in EDGE; the
+// OIDF fanout is not read), and we don't follow the "base graph" chain used to split very large repositories
+// across multiple incremental files.
+
+var nativeFileSignature = [4]byte{'C', 'G', 'P', 'H'}
+
+const nativeFileVersion = 1
+
+var (
+	nativeChunkOIDFanout  = [4]byte{'O', 'I', 'D', 'F'}
+	nativeChunkOIDLookup  = [4]byte{'O', 'I', 'D', 'L'}
+	nativeChunkCommitData = [4]byte{'C', 'D', 'A', 'T'}
+	nativeChunkExtraEdges = [4]byte{'E', 'D', 'G', 'E'}
+)
+
+const (
+	// graphParentNone marks an unused parent slot in a CDAT record.
+	graphParentNone = 0x70000000
+	// graphParentExtraEdges marks the second parent slot in a CDAT record as pointing into
+	// the EDGE chunk (for octopus merges) rather than holding a parent position directly.
+	graphParentExtraEdges = 0x80000000
+	graphParentMask       = 0x7fffffff
+)
+
+// ReadNativeCommitGraph parses a commit-graph file written by `git commit-graph write` and
+// returns it in the same shape ParseCommitGraph produces from `git log` text output. This lets
+// callers read the file Git already maintains on disk instead of shelling out to `git log` and
+// re-parsing its text output on every request.
+func ReadNativeCommitGraph(r io.Reader) (*CommitGraph, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 8 || [4]byte{data[0], data[1], data[2], data[3]} != nativeFileSignature {
+		return nil, fmt.Errorf("gitserver: not a commit-graph file")
+	}
+
+	version, hashVersion, numChunks := data[4], data[5], int(data[6])
+	if version != nativeFileVersion {
+		return nil, fmt.Errorf("gitserver: unsupported commit-graph version %d", version)
+	}
+
+	hashLen, err := nativeHashLen(hashVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	// Table of contents: numChunks entries plus a terminating sentinel, each 12 bytes (a
+	// 4-byte chunk ID and an 8-byte offset), immediately following the 8-byte header. A
+	// chunk's extent runs from its own offset up to the next entry's offset.
+	const tocStart = 8
+	const tocEntrySize = 12
+	if len(data) < tocStart+(numChunks+1)*tocEntrySize {
+		return nil, fmt.Errorf("gitserver: commit-graph table of contents is truncated")
+	}
+
+	chunks := make(map[[4]byte][]byte, numChunks)
+	for i := 0; i < numChunks; i++ {
+		entry := tocStart + i*tocEntrySize
+		id := [4]byte{data[entry], data[entry+1], data[entry+2], data[entry+3]}
+		start := binary.BigEndian.Uint64(data[entry+4 : entry+12])
+		end := binary.BigEndian.Uint64(data[entry+tocEntrySize+4 : entry+tocEntrySize+12])
+		if end > uint64(len(data)) || start > end {
+			return nil, fmt.Errorf("gitserver: commit-graph chunk %q overruns file", id)
+		}
+
+		chunks[id] = data[start:end]
+	}
+
+	oidLookup, ok := chunks[nativeChunkOIDLookup]
+	if !ok {
+		return nil, fmt.Errorf("gitserver: commit-graph file is missing the OIDL chunk")
+	}
+	commitData, ok := chunks[nativeChunkCommitData]
+	if !ok {
+		return nil, fmt.Errorf("gitserver: commit-graph file is missing the CDAT chunk")
+	}
+	extraEdges := chunks[nativeChunkExtraEdges]
+
+	if hashLen == 0 || len(oidLookup)%hashLen != 0 {
+		return nil, fmt.Errorf("gitserver: commit-graph OIDL chunk has unexpected length %d", len(oidLookup))
+	}
+	numCommits := len(oidLookup) / hashLen
+
+	hashes := make([]string, numCommits)
+	for i := 0; i < numCommits; i++ {
+		hashes[i] = fmt.Sprintf("%x", oidLookup[i*hashLen:(i+1)*hashLen])
+	}
+
+	resolveParent := func(pos uint32) (string, bool) {
+		if pos == graphParentNone || int(pos) >= numCommits {
+			return "", false
+		}
+		return hashes[pos], true
+	}
+
+	// Each CDAT record is the root tree OID, two 4-byte parent positions, and an 8-byte
+	// generation number/commit date field we don't need here (generation numbers are
+	// recomputed by commitgraph.NewGraph from the edges alone).
+	const recordTrailerSize = 16
+	recordSize := hashLen + recordTrailerSize
+	if len(commitData) != numCommits*recordSize {
+		return nil, fmt.Errorf("gitserver: commit-graph CDAT chunk has unexpected length %d", len(commitData))
+	}
+
+	graph := make(map[string][]string, numCommits)
+	for i := 0; i < numCommits; i++ {
+		record := commitData[i*recordSize : (i+1)*recordSize]
+		parent1 := binary.BigEndian.Uint32(record[hashLen : hashLen+4])
+		parent2 := binary.BigEndian.Uint32(record[hashLen+4 : hashLen+8])
+
+		var parents []string
+		if name, ok := resolveParent(parent1); ok {
+			parents = append(parents, name)
+		}
+
+		if parent2&graphParentExtraEdges != 0 {
+			extra, err := resolveExtraEdges(extraEdges, parent2&graphParentMask, resolveParent)
+			if err != nil {
+				return nil, err
+			}
+			parents = append(parents, extra...)
+		} else if name, ok := resolveParent(parent2); ok {
+			parents = append(parents, name)
+		}
+
+		graph[hashes[i]] = parents
+	}
+
+	return &CommitGraph{graph: graph, order: topoSortParentsFirst(graph, hashes)}, nil
+}
+
+// resolveExtraEdges reads the octopus-merge parent positions for a commit out of the EDGE
+// chunk, starting at pos, until it reads an entry with the high bit set (which marks the last
+// parent in the list).
+func resolveExtraEdges(extraEdges []byte, pos uint32, resolveParent func(uint32) (string, bool)) ([]string, error) {
+	var parents []string
+
+	for {
+		offset := int(pos) * 4
+		if offset+4 > len(extraEdges) {
+			return nil, fmt.Errorf("gitserver: commit-graph extra edge list overruns the EDGE chunk")
+		}
+
+		edge := binary.BigEndian.Uint32(extraEdges[offset : offset+4])
+		if name, ok := resolveParent(edge & graphParentMask); ok {
+			parents = append(parents, name)
+		}
+		if edge&graphParentExtraEdges != 0 {
+			return parents, nil
+		}
+
+		pos++
+	}
+}
+
+func nativeHashLen(hashVersion byte) (int, error) {
+	switch hashVersion {
+	case 1:
+		return 20, nil // SHA-1
+	case 2:
+		return 32, nil // SHA-256
+	default:
+		return 0, fmt.Errorf("gitserver: unsupported commit-graph hash version %d", hashVersion)
+	}
+}
@@ -0,0 +1,144 @@
+package gitserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadNativeCommitGraph(t *testing.T) {
+	// Builds the graph "c -> b -> a" (c's parent is b, b's parent is a) plus an octopus
+	// merge "d -> b, c" to exercise the EDGE chunk, using 20-byte (SHA-1) object IDs.
+	oids := map[string][20]byte{
+		"a": oid(0x01),
+		"b": oid(0x02),
+		"c": oid(0x03),
+		"d": oid(0x04),
+	}
+
+	order := []string{"a", "b", "c", "d"} // sorted by OID, as OIDL requires
+	index := make(map[string]uint32, len(order))
+	for i, commit := range order {
+		index[commit] = uint32(i)
+	}
+
+	var oidLookup bytes.Buffer
+	for _, commit := range order {
+		oid := oids[commit]
+		oidLookup.Write(oid[:])
+	}
+
+	extraEdges := []uint32{
+		index["c"] | graphParentExtraEdges, // sole entry, so also the last
+	}
+	var edgeChunk bytes.Buffer
+	for _, edge := range extraEdges {
+		binary.Write(&edgeChunk, binary.BigEndian, edge)
+	}
+
+	parents := map[string][2]uint32{
+		"a": {graphParentNone, graphParentNone},
+		"b": {index["a"], graphParentNone},
+		"c": {index["b"], graphParentNone},
+		"d": {index["b"], 0 | graphParentExtraEdges}, // parent2 points at offset 0 in EDGE
+	}
+
+	var commitData bytes.Buffer
+	for _, commit := range order {
+		commitData.Write(make([]byte, 20)) // root tree OID, unused by the reader
+		p := parents[commit]
+		binary.Write(&commitData, binary.BigEndian, p[0])
+		binary.Write(&commitData, binary.BigEndian, p[1])
+		commitData.Write(make([]byte, 8)) // generation number / commit date, unused
+	}
+
+	file := encodeTestCommitGraphFile(oidLookup.Bytes(), commitData.Bytes(), edgeChunk.Bytes())
+
+	graph, err := ReadNativeCommitGraph(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("unexpected error reading commit-graph file: %s", err)
+	}
+
+	hash := func(commit string) string {
+		oid := oids[commit]
+		return fmt.Sprintf("%x", oid[:])
+	}
+	want := map[string][]string{
+		hash("a"): nil,
+		hash("b"): {hash("a")},
+		hash("c"): {hash("b")},
+		hash("d"): {hash("b"), hash("c")},
+	}
+	got := graph.Graph()
+	for commit := range got {
+		sort.Strings(got[commit])
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected graph (-want +got):\n%s", diff)
+	}
+
+	assertParentsBeforeChildren(t, graph)
+}
+
+func oid(b byte) [20]byte {
+	var out [20]byte
+	out[19] = b
+	return out
+}
+
+// encodeTestCommitGraphFile assembles a minimal, single-base commit-graph file with the
+// three chunks ReadNativeCommitGraph requires (OIDF, OIDL, CDAT) plus an optional EDGE
+// chunk, mirroring the layout `git commit-graph write` produces.
+func encodeTestCommitGraphFile(oidLookup, commitData, edgeChunk []byte) []byte {
+	type chunk struct {
+		id      [4]byte
+		payload []byte
+	}
+
+	fanout := make([]byte, 256*4)
+	// A single fanout bucket covering every OID is sufficient for this reader, which never
+	// consults OIDF itself; it only needs to be present so the chunk count matches a real file.
+	binary.BigEndian.PutUint32(fanout[255*4:], uint32(len(oidLookup)/20))
+
+	chunks := []chunk{
+		{nativeChunkOIDFanout, fanout},
+		{nativeChunkOIDLookup, oidLookup},
+		{nativeChunkCommitData, commitData},
+	}
+	if len(edgeChunk) > 0 {
+		chunks = append(chunks, chunk{nativeChunkExtraEdges, edgeChunk})
+	}
+
+	var buf bytes.Buffer
+	buf.Write(nativeFileSignature[:])
+	buf.WriteByte(nativeFileVersion)
+	buf.WriteByte(1) // hash version: SHA-1
+	buf.WriteByte(byte(len(chunks)))
+	buf.WriteByte(0) // base graph count
+
+	headerSize := 8 + (len(chunks)+1)*12
+	offset := headerSize
+	for _, c := range chunks {
+		buf.Write(c.id[:])
+		var offsetBytes [8]byte
+		binary.BigEndian.PutUint64(offsetBytes[:], uint64(offset))
+		buf.Write(offsetBytes[:])
+		offset += len(c.payload)
+	}
+	// Terminating TOC entry: zero ID, offset marking the end of the last chunk.
+	buf.Write(make([]byte, 4))
+	var endBytes [8]byte
+	binary.BigEndian.PutUint64(endBytes[:], uint64(offset))
+	buf.Write(endBytes[:])
+
+	for _, c := range chunks {
+		buf.Write(c.payload)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,24 @@
+package gitserver
+
+import "io"
+
+// CommitGraphSource describes where a repository's commit graph should be read from.
+// NativeFile, when non-nil, takes priority: it lets a caller that already has a commit-graph
+// file written by `git commit-graph write` on disk (see ReadNativeCommitGraph) skip the
+// `git log --topo-order --format="%H %P"` shell-out and text parsing that Lines would
+// otherwise require. Lines is the fallback, used for repositories Git hasn't (or can't)
+// written a commit-graph file for.
+type CommitGraphSource struct {
+	NativeFile io.Reader
+	Lines      []string
+}
+
+// ResolveCommitGraph returns the CommitGraph described by src, preferring NativeFile over
+// Lines when both are set.
+func ResolveCommitGraph(src CommitGraphSource) (*CommitGraph, error) {
+	if src.NativeFile != nil {
+		return ReadNativeCommitGraph(src.NativeFile)
+	}
+
+	return ParseCommitGraph(src.Lines), nil
+}
@@ -0,0 +1,52 @@
+package gitserver
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestResolveCommitGraphPrefersNativeFile(t *testing.T) {
+	var native bytes.Buffer
+	native.Write(nativeFileSignature[:])
+	native.WriteByte(nativeFileVersion)
+	native.WriteByte(1) // SHA-1
+	native.WriteByte(2) // OIDL, CDAT
+	native.WriteByte(0)
+	// A minimal, empty graph: a table of contents with two zero-length chunks is enough for
+	// ReadNativeCommitGraph to succeed with numCommits == 0, which is all this test needs to
+	// prove NativeFile was the one actually read.
+	writeChunkEntry := func(id [4]byte, offset uint64) {
+		native.Write(id[:])
+		var buf [8]byte
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(offset)
+			offset >>= 8
+		}
+		native.Write(buf[:])
+	}
+	dataStart := uint64(native.Len()) + 3*12
+	writeChunkEntry(nativeChunkOIDLookup, dataStart)
+	writeChunkEntry(nativeChunkCommitData, dataStart)
+	writeChunkEntry([4]byte{0, 0, 0, 0}, dataStart)
+
+	graph, err := ResolveCommitGraph(CommitGraphSource{
+		NativeFile: bytes.NewReader(native.Bytes()),
+		Lines:      []string{"a"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(graph.Graph()) != 0 {
+		t.Fatalf("expected the empty native graph to be used, got %v", graph.Graph())
+	}
+}
+
+func TestResolveCommitGraphFallsBackToLines(t *testing.T) {
+	graph, err := ResolveCommitGraph(CommitGraphSource{Lines: []string{"b a", "a"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := graph.Graph()["b"]; !ok {
+		t.Fatalf("expected Lines to be parsed when NativeFile is unset, got %v", graph.Graph())
+	}
+}
@@ -0,0 +1,108 @@
+package janitor
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/sourcegraph/sourcegraph/enterprise/internal/codeintel/gitserver"
+)
+
+// Store is the subset of dbstore.Store the commit pruner needs. It's declared here rather than
+// depending on the dbstore package directly so this package stays testable without a database.
+type Store interface {
+	PruneOrphanedCommits(ctx context.Context, repositoryID int, reachableCommits []string) (int, error)
+}
+
+// RepositoryLister supplies the set of repositories the commit pruner should visit on each
+// sweep.
+type RepositoryLister interface {
+	ListRepositoryIDsWithLSIFData(ctx context.Context) ([]int, error)
+}
+
+// CommitGraphLoader supplies the full commit graph gitserver knows about for a repository: the
+// set of commits reachable from some branch or tag tip. Any commit a repository's rows
+// reference that is absent from this graph is, by construction, unreachable and safe to prune.
+type CommitGraphLoader interface {
+	CommitGraph(ctx context.Context, repositoryID int) (*gitserver.CommitGraph, error)
+}
+
+// CommitPruner periodically deletes LSIF visibility rows left behind by commits that are no
+// longer reachable from any ref, e.g. after a rebase or a force-push.
+type CommitPruner struct {
+	store    Store
+	repos    RepositoryLister
+	graphs   CommitGraphLoader
+	interval time.Duration
+
+	numCommitsPruned     int64
+	numRepositoriesSwept int64
+}
+
+// NewCommitPruner constructs a CommitPruner that sweeps every repository returned by repos
+// once per interval.
+func NewCommitPruner(store Store, repos RepositoryLister, graphs CommitGraphLoader, interval time.Duration) *CommitPruner {
+	return &CommitPruner{store: store, repos: repos, graphs: graphs, interval: interval}
+}
+
+// Start runs the pruner's sweep loop until ctx is canceled.
+func (p *CommitPruner) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.sweep(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// NumCommitsPruned returns the cumulative number of rows this pruner has deleted, across all
+// repositories and sweeps, since it was constructed.
+func (p *CommitPruner) NumCommitsPruned() int64 {
+	return atomic.LoadInt64(&p.numCommitsPruned)
+}
+
+// NumRepositoriesSwept returns the cumulative number of repositories this pruner has visited
+// since it was constructed.
+func (p *CommitPruner) NumRepositoriesSwept() int64 {
+	return atomic.LoadInt64(&p.numRepositoriesSwept)
+}
+
+func (p *CommitPruner) sweep(ctx context.Context) {
+	repositoryIDs, err := p.repos.ListRepositoryIDsWithLSIFData(ctx)
+	if err != nil {
+		log15.Error("commit pruner: failed to list repositories", "error", err)
+		return
+	}
+
+	for _, repositoryID := range repositoryIDs {
+		if err := p.sweepRepository(ctx, repositoryID); err != nil {
+			log15.Error("commit pruner: failed to prune repository", "repositoryID", repositoryID, "error", err)
+		}
+	}
+}
+
+func (p *CommitPruner) sweepRepository(ctx context.Context, repositoryID int) error {
+	commitGraph, err := p.graphs.CommitGraph(ctx, repositoryID)
+	if err != nil {
+		return err
+	}
+
+	count, err := p.store.PruneOrphanedCommits(ctx, repositoryID, commitGraph.Order())
+	if err != nil {
+		return err
+	}
+
+	atomic.AddInt64(&p.numRepositoriesSwept, 1)
+	if count > 0 {
+		atomic.AddInt64(&p.numCommitsPruned, int64(count))
+		log15.Info("commit pruner: pruned orphaned commits", "repositoryID", repositoryID, "count", count)
+	}
+
+	return nil
+}
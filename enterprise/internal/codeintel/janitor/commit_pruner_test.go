@@ -0,0 +1,63 @@
+package janitor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sourcegraph/sourcegraph/enterprise/internal/codeintel/gitserver"
+)
+
+type fakeStore struct {
+	pruned map[int][]string
+}
+
+func (s *fakeStore) PruneOrphanedCommits(_ context.Context, repositoryID int, reachableCommits []string) (int, error) {
+	if s.pruned == nil {
+		s.pruned = map[int][]string{}
+	}
+	s.pruned[repositoryID] = reachableCommits
+
+	return len(reachableCommits), nil
+}
+
+type fakeRepositoryLister struct {
+	repositoryIDs []int
+}
+
+func (l *fakeRepositoryLister) ListRepositoryIDsWithLSIFData(_ context.Context) ([]int, error) {
+	return l.repositoryIDs, nil
+}
+
+type fakeCommitGraphLoader struct {
+	graphs map[int]*gitserver.CommitGraph
+}
+
+func (l *fakeCommitGraphLoader) CommitGraph(_ context.Context, repositoryID int) (*gitserver.CommitGraph, error) {
+	return l.graphs[repositoryID], nil
+}
+
+func TestCommitPrunerSweep(t *testing.T) {
+	store := &fakeStore{}
+	repos := &fakeRepositoryLister{repositoryIDs: []int{50, 51}}
+	graphs := &fakeCommitGraphLoader{graphs: map[int]*gitserver.CommitGraph{
+		50: gitserver.ParseCommitGraph([]string{"b a"}),
+		51: gitserver.ParseCommitGraph([]string{"d c", "c a"}),
+	}}
+
+	pruner := NewCommitPruner(store, repos, graphs, 0)
+	pruner.sweep(context.Background())
+
+	if diff := len(store.pruned[50]); diff != 2 {
+		t.Errorf("expected 2 reachable commits passed for repository 50, got %d", diff)
+	}
+	if diff := len(store.pruned[51]); diff != 3 {
+		t.Errorf("expected 3 reachable commits passed for repository 51, got %d", diff)
+	}
+
+	if got := pruner.NumRepositoriesSwept(); got != 2 {
+		t.Errorf("expected 2 repositories swept, got %d", got)
+	}
+	if got := pruner.NumCommitsPruned(); got != 5 {
+		t.Errorf("expected 5 commits pruned in total, got %d", got)
+	}
+}
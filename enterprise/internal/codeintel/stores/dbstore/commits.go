@@ -27,7 +27,7 @@ func scanCommitGraphView(rows *sql.Rows, queryErr error) (_ *commitgraph.CommitG
 		var meta commitgraph.UploadMeta
 		var commit, token string
 
-		if err := rows.Scan(&meta.UploadID, &commit, &token, &meta.Distance); err != nil {
+		if err := rows.Scan(&meta.UploadID, &commit, &token, &meta.Flags); err != nil {
 			return nil, err
 		}
 
@@ -158,13 +158,21 @@ func (s *Store) CalculateVisibleUploads(ctx context.Context, repositoryID int, c
 	// Determine which uploads are visible to which commits for this repository
 	graph := commitgraph.NewGraph(commitGraph, commitGraphView)
 
+	return tx.writeVisibleUploads(ctx, repositoryID, graph, tipCommit, dirtyToken)
+}
+
+// writeVisibleUploads clears the previously computed visibility data for repositoryID and
+// replaces it with the data carried by graph. It is the shared write path behind
+// CalculateVisibleUploads and CalculateVisibleUploadsWithCache, which differ only in how graph
+// is obtained (always recomputed vs. served from a commitgraph.GraphCache when possible).
+func (s *Store) writeVisibleUploads(ctx context.Context, repositoryID int, graph *commitgraph.Graph, tipCommit string, dirtyToken int) error {
 	// Clear all old visibility data for this repository
 	for _, query := range []string{
 		`DELETE FROM lsif_nearest_uploads WHERE repository_id = %s`,
 		`DELETE FROM lsif_nearest_uploads_links WHERE repository_id = %s`,
 		`DELETE FROM lsif_uploads_visible_at_tip WHERE repository_id = %s`,
 	} {
-		if err := tx.Store.Exec(ctx, sqlf.Sprintf(query, repositoryID)); err != nil {
+		if err := s.Store.Exec(ctx, sqlf.Sprintf(query, repositoryID)); err != nil {
 			return err
 		}
 	}
@@ -239,7 +247,7 @@ func (s *Store) CalculateVisibleUploads(ctx context.Context, repositoryID int, c
 		// the dirty token if it wouldn't decrease the value. Dirty repositories are determined
 		// by having a non-equal dirty and update token, and we want the most recent upload
 		// token to win this write.
-		if err := tx.Store.Exec(ctx, sqlf.Sprintf(
+		if err := s.Store.Exec(ctx, sqlf.Sprintf(
 			`UPDATE lsif_dirty_repositories SET update_token = GREATEST(update_token, %s) WHERE repository_id = %s`,
 			dirtyToken,
 			repositoryID,
@@ -259,7 +267,7 @@ func batchInsertUpload(ctx context.Context, repositoryID int, inserter *batch.Ba
 			repositoryID,
 			dbutil.CommitBytea(uploads.Commit),
 			uploadMeta.UploadID,
-			uploadMeta.Distance,
+			uploadMeta.Flags&commitgraph.MaxDistance,
 		); err != nil {
 			return err
 		}
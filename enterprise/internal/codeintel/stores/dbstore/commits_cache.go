@@ -0,0 +1,133 @@
+package dbstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+
+	"github.com/keegancsmith/sqlf"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/sourcegraph/sourcegraph/enterprise/internal/codeintel/commitgraph"
+	"github.com/sourcegraph/sourcegraph/enterprise/internal/codeintel/gitserver"
+	"github.com/sourcegraph/sourcegraph/internal/db/basestore"
+	"github.com/sourcegraph/sourcegraph/internal/observation"
+)
+
+// hashUploadTokens returns a stable fingerprint of the uploads a CommitGraphView was built
+// from, so that CalculateVisibleUploadsWithCache can tell whether a previously cached Graph was
+// computed from the same upload set (as opposed to merely the same commit graph).
+func hashUploadTokens(commitGraphView *commitgraph.CommitGraphView) string {
+	type entry struct {
+		uploadID int
+		commit   string
+		token    string
+	}
+
+	var entries []entry
+	for commit, metas := range commitGraphView.Meta {
+		for _, meta := range metas {
+			entries = append(entries, entry{meta.UploadID, commit, commitGraphView.Tokens[meta.UploadID]})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].uploadID != entries[j].uploadID {
+			return entries[i].uploadID < entries[j].uploadID
+		}
+		return entries[i].commit < entries[j].commit
+	})
+
+	h := sha256.New()
+	for _, e := range entries {
+		h.Write([]byte(strconv.Itoa(e.uploadID)))
+		h.Write([]byte{0})
+		h.Write([]byte(e.commit))
+		h.Write([]byte{0})
+		h.Write([]byte(e.token))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// CalculateVisibleUploadsWithCache behaves like CalculateVisibleUploads, but consults cache
+// before paying the cost of commitgraph.NewGraph's full traversal. A full cache hit requires the
+// commit graph, the upload set, and the repository's current lsif_dirty_repositories.update_token
+// to all match what produced the cached entry. A partial hit -- same commit graph and dirty
+// token, different uploads -- still skips recomputing generation numbers (see
+// commitgraph.PopulateUploads). Either way the freshly computed or reconstituted graph is stored
+// back into cache for the next call, and any entry left over from before the repository's most
+// recent dirty-token bump is evicted first so it can't be served as current.
+func (s *Store) CalculateVisibleUploadsWithCache(ctx context.Context, repositoryID int, commitGraph *gitserver.CommitGraph, tipCommit string, dirtyToken int, cache commitgraph.GraphCache) (err error) {
+	ctx, endObservation := s.operations.calculateVisibleUploadsWithCache.With(ctx, &err, observation.Args{LogFields: []log.Field{
+		log.Int("repositoryID", repositoryID),
+		log.Int("numKeys", len(commitGraph.Order())),
+		log.String("tipCommit", tipCommit),
+		log.Int("dirtyToken", dirtyToken),
+	}})
+	defer endObservation(1, observation.Args{})
+
+	tx, err := s.transact(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { err = tx.Done(err) }()
+
+	commitGraphView, err := scanCommitGraphView(tx.Store.Query(ctx, sqlf.Sprintf(`
+		SELECT id, commit, md5(root || ':' || indexer) as token, 0 as distance
+		FROM lsif_uploads
+		WHERE state = 'completed' AND repository_id = %s
+	`, repositoryID)))
+	if err != nil {
+		return err
+	}
+
+	currentDirtyToken, _, err := basestore.ScanFirstInt(tx.Store.Query(ctx, sqlf.Sprintf(
+		`SELECT update_token FROM lsif_dirty_repositories WHERE repository_id = %s`,
+		repositoryID,
+	)))
+	if err != nil {
+		return err
+	}
+
+	// Discard any entry computed before this repository's most recent dirty-token bump before
+	// consulting the cache below, so a stale entry left over from before that write is never
+	// mistaken for one still valid under the write's effects.
+	if err := cache.Evict(ctx, repositoryID, currentDirtyToken); err != nil {
+		return err
+	}
+
+	key := commitgraph.CacheKey{
+		RepositoryID:    repositoryID,
+		CommitGraphHash: commitSetKey(commitGraph.Order()),
+		UploadsHash:     hashUploadTokens(commitGraphView),
+		DirtyToken:      currentDirtyToken,
+	}
+
+	graph, ok, err := cache.Get(ctx, key, commitGraph, commitGraphView)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// A partial hit means the commit graph hasn't changed since it was last decorated, just
+		// the uploads; commitgraph.PopulateUploads still has to redo the upload traversal in
+		// both directions (see its doc comment for why), but at least skips recomputing
+		// generation numbers from scratch.
+		if graph, ok, err = cache.GetPartial(ctx, key, commitGraph); err != nil {
+			return err
+		}
+		if ok {
+			commitgraph.PopulateUploads(graph, commitGraph, commitGraphView)
+		} else {
+			graph = commitgraph.NewGraph(commitGraph, commitGraphView)
+		}
+
+		if err := cache.Put(ctx, key, graph); err != nil {
+			return err
+		}
+	}
+
+	return tx.writeVisibleUploads(ctx, repositoryID, graph, tipCommit, dirtyToken)
+}
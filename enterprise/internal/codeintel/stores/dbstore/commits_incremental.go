@@ -0,0 +1,390 @@
+package dbstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"github.com/keegancsmith/sqlf"
+	"github.com/lib/pq"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/sourcegraph/sourcegraph/enterprise/internal/codeintel/commitgraph"
+	"github.com/sourcegraph/sourcegraph/enterprise/internal/codeintel/gitserver"
+	"github.com/sourcegraph/sourcegraph/internal/db/basestore"
+	"github.com/sourcegraph/sourcegraph/internal/db/batch"
+	"github.com/sourcegraph/sourcegraph/internal/db/dbutil"
+	"github.com/sourcegraph/sourcegraph/internal/observation"
+)
+
+// NOTE: this file assumes a `graph_key text` column has been added to lsif_dirty_repositories
+// alongside update_token. That migration isn't included here: it belongs in the
+// migrations/frontend tree, which isn't part of this checkout.
+
+// commitSetKey returns a stable fingerprint of a set of commits, ignoring order. It is used by
+// CalculateVisibleUploadsIncremental to confirm that the commits it isn't going to touch are
+// exactly the commits it touched (or inherited) on the previous run, before trusting that their
+// rows in lsif_nearest_uploads and lsif_nearest_uploads_links are still accurate.
+func commitSetKey(commits []string) string {
+	sorted := append([]string(nil), commits...)
+	sort.Strings(sorted)
+
+	h := sha256.New()
+	for _, commit := range sorted {
+		h.Write([]byte(commit))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// CalculateVisibleUploadsIncremental is a cheaper alternative to CalculateVisibleUploads for the
+// common case where a repository's commit graph has simply grown since the last run. It is a
+// write-side optimization only: commitgraph.NewGraph has no way to update a previously computed
+// Graph in place (see BenchmarkNewGraphAppendingTip), so this still runs NewGraph/Gather over the
+// entire commitGraph on every call, at a cost that grows with the size of the whole history, not
+// with the size of affectedCommits. What it narrows is which rows that full recompute's result
+// actually gets written back to Postgres for: newCommits (commits gitserver has not seen before),
+// newUploadCommits (an upload completed against a commit that already existed), and -- found by
+// findCommitsWithChangedVisibility -- any other commit whose nearest-upload answer the fresh
+// Gather() actually disagrees with what's currently stored for it. That last category matters
+// because a new upload's visibility isn't confined to the commits it or its direct neighbors
+// touch: a descendant commit many hops away can have its true nearest upload for some root get
+// closer purely because a new ancestor upload just appeared, and that commit's stored row would
+// otherwise never be corrected by this append-only fast path.
+//
+// This is only safe when the commits it is NOT rewriting are unchanged from the last run: since
+// commit hashes are content-addressed, a commit's parents can't change without it becoming a
+// different commit, so it's enough to confirm that the set of "old" commits (everything in
+// commitGraph.Order() other than newCommits) matches the set recorded by the previous call. If it
+// doesn't -- a rebase, a force-push that dropped commits, or simply the first run for this
+// repository -- this falls back to a full CalculateVisibleUploads rather than risk leaving stale
+// rows in place.
+//
+// Because every row this method writes comes from a single Gather() over the freshly computed
+// graph, link rows it inserts always point directly at the nearest commit actually storing
+// upload data (see commitgraph.traverseForCommit), the same path-compression guarantee
+// CalculateVisibleUploads provides -- an incremental update never leaves behind a multi-hop
+// chain of link rows.
+func (s *Store) CalculateVisibleUploadsIncremental(ctx context.Context, repositoryID int, commitGraph *gitserver.CommitGraph, newCommits, newUploadCommits []string, tipCommit string, dirtyToken int) (err error) {
+	ctx, endObservation := s.operations.calculateVisibleUploadsIncremental.With(ctx, &err, observation.Args{LogFields: []log.Field{
+		log.Int("repositoryID", repositoryID),
+		log.Int("numKeys", len(commitGraph.Order())),
+		log.Int("numNewCommits", len(newCommits)),
+		log.Int("numNewUploadCommits", len(newUploadCommits)),
+		log.String("tipCommit", tipCommit),
+		log.Int("dirtyToken", dirtyToken),
+	}})
+	defer endObservation(1, observation.Args{})
+
+	tx, err := s.transact(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { err = tx.Done(err) }()
+
+	newCommitSet := make(map[string]struct{}, len(newCommits))
+	for _, commit := range newCommits {
+		newCommitSet[commit] = struct{}{}
+	}
+
+	oldCommits := make([]string, 0, len(commitGraph.Order()))
+	for _, commit := range commitGraph.Order() {
+		if _, ok := newCommitSet[commit]; !ok {
+			oldCommits = append(oldCommits, commit)
+		}
+	}
+
+	storedKey, ok, err := basestore.ScanFirstString(tx.Store.Query(ctx, sqlf.Sprintf(
+		`SELECT graph_key FROM lsif_dirty_repositories WHERE repository_id = %s`,
+		repositoryID,
+	)))
+	if err != nil {
+		return err
+	}
+	if !ok || storedKey != commitSetKey(oldCommits) {
+		return tx.calculateVisibleUploadsFull(ctx, repositoryID, commitGraph, tipCommit, dirtyToken)
+	}
+
+	commitGraphView, err := scanCommitGraphView(tx.Store.Query(ctx, sqlf.Sprintf(`
+		SELECT id, commit, md5(root || ':' || indexer) as token, 0 as distance
+		FROM lsif_uploads
+		WHERE state = 'completed' AND repository_id = %s
+	`, repositoryID)))
+	if err != nil {
+		return err
+	}
+
+	graph := commitgraph.NewGraph(commitGraph, commitGraphView)
+	uploadsByCommit, linksByCommit := graph.Gather()
+
+	changedCommits, err := tx.findCommitsWithChangedVisibility(ctx, repositoryID, oldCommits, uploadsByCommit, linksByCommit)
+	if err != nil {
+		return err
+	}
+
+	affectedCommitSet := make(map[string]struct{}, len(newCommits)+len(newUploadCommits)+len(changedCommits))
+	affectedCommits := make([]string, 0, len(newCommits)+len(newUploadCommits)+len(changedCommits))
+	for _, commit := range append(append(append([]string(nil), newCommits...), newUploadCommits...), changedCommits...) {
+		if _, ok := affectedCommitSet[commit]; ok {
+			continue
+		}
+		affectedCommitSet[commit] = struct{}{}
+		affectedCommits = append(affectedCommits, commit)
+	}
+
+	affectedCommitByteas := make([][]byte, len(affectedCommits))
+	for i, commit := range affectedCommits {
+		affectedCommitByteas[i] = dbutil.CommitBytea(commit)
+	}
+
+	for _, query := range []string{
+		`DELETE FROM lsif_nearest_uploads WHERE repository_id = %s AND commit_bytea = ANY(%s)`,
+		`DELETE FROM lsif_nearest_uploads_links WHERE repository_id = %s AND commit_bytea = ANY(%s)`,
+	} {
+		if err := tx.Store.Exec(ctx, sqlf.Sprintf(query, repositoryID, pq.Array(affectedCommitByteas))); err != nil {
+			return err
+		}
+	}
+
+	nearestUploadsInserter := batch.NewBatchInserter(
+		ctx,
+		s.Store.Handle().DB(),
+		"lsif_nearest_uploads",
+		"repository_id",
+		"commit_bytea",
+		"upload_id",
+		"distance",
+	)
+	nearestUploadsLinksInserter := batch.NewBatchInserter(
+		ctx,
+		s.Store.Handle().DB(),
+		"lsif_nearest_uploads_links",
+		"repository_id",
+		"commit_bytea",
+		"ancestor_commit_bytea",
+		"distance",
+	)
+
+	for _, commit := range affectedCommits {
+		if uploads, ok := uploadsByCommit[commit]; ok {
+			if err := batchInsertUpload(ctx, repositoryID, nearestUploadsInserter, &commitgraph.VisibilityRelationship{Commit: commit, Uploads: uploads}); err != nil {
+				return err
+			}
+		}
+		if link, ok := linksByCommit[commit]; ok {
+			if err := batchInsertLinks(ctx, repositoryID, nearestUploadsLinksInserter, &link); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := nearestUploadsInserter.Flush(ctx); err != nil {
+		return err
+	}
+	if err := nearestUploadsLinksInserter.Flush(ctx); err != nil {
+		return err
+	}
+
+	if err := tx.Store.Exec(ctx, sqlf.Sprintf(
+		`DELETE FROM lsif_uploads_visible_at_tip WHERE repository_id = %s`,
+		repositoryID,
+	)); err != nil {
+		return err
+	}
+
+	uploadsVisibleAtTipInserter := batch.NewBatchInserter(
+		ctx,
+		s.Store.Handle().DB(),
+		"lsif_uploads_visible_at_tip",
+		"repository_id",
+		"upload_id",
+	)
+	for _, uploadMeta := range graph.UploadsVisibleAtCommit(tipCommit) {
+		if err := uploadsVisibleAtTipInserter.Insert(ctx, repositoryID, uploadMeta.UploadID); err != nil {
+			return err
+		}
+	}
+	if err := uploadsVisibleAtTipInserter.Flush(ctx); err != nil {
+		return err
+	}
+
+	if err := tx.Store.Exec(ctx, sqlf.Sprintf(
+		`UPDATE lsif_dirty_repositories SET graph_key = %s WHERE repository_id = %s`,
+		commitSetKey(commitGraph.Order()),
+		repositoryID,
+	)); err != nil {
+		return err
+	}
+
+	if dirtyToken != 0 {
+		if err := tx.Store.Exec(ctx, sqlf.Sprintf(
+			`UPDATE lsif_dirty_repositories SET update_token = GREATEST(update_token, %s) WHERE repository_id = %s`,
+			dirtyToken,
+			repositoryID,
+		)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// calculateVisibleUploadsFull mirrors Store.CalculateVisibleUploads, additionally recording the
+// graph_key fingerprint CalculateVisibleUploadsIncremental relies on to determine whether a
+// future run can skip recomputing visibility for the commits it already processed here.
+func (s *Store) calculateVisibleUploadsFull(ctx context.Context, repositoryID int, commitGraph *gitserver.CommitGraph, tipCommit string, dirtyToken int) error {
+	if err := s.CalculateVisibleUploads(ctx, repositoryID, commitGraph, tipCommit, dirtyToken); err != nil {
+		return err
+	}
+
+	return s.Store.Exec(ctx, sqlf.Sprintf(
+		`UPDATE lsif_dirty_repositories SET graph_key = %s WHERE repository_id = %s`,
+		commitSetKey(commitGraph.Order()),
+		repositoryID,
+	))
+}
+
+// uploadRow and linkRow mirror a single row of lsif_nearest_uploads and
+// lsif_nearest_uploads_links, decoded back to the same shape Gather() produces, so that
+// findCommitsWithChangedVisibility can compare the two directly.
+type uploadRow struct {
+	uploadID int
+	distance uint32
+}
+
+type linkRow struct {
+	ancestor string
+	distance uint32
+}
+
+// findCommitsWithChangedVisibility compares the visibility graph.Gather() computed for each of
+// oldCommits (uploadsByCommit, linksByCommit) against what's currently stored for repositoryID in
+// lsif_nearest_uploads and lsif_nearest_uploads_links, and returns the commits where they
+// disagree: these are commits CalculateVisibleUploadsIncremental would otherwise leave with a
+// stale row even though they are neither new nor directly holding a new upload (see its doc
+// comment for why this can happen).
+func (s *Store) findCommitsWithChangedVisibility(ctx context.Context, repositoryID int, oldCommits []string, uploadsByCommit map[string][]commitgraph.UploadMeta, linksByCommit map[string]commitgraph.LinkRelationship) ([]string, error) {
+	existingUploads, existingLinks, err := s.scanExistingVisibility(ctx, repositoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	for _, commit := range oldCommits {
+		if uploads, ok := uploadsByCommit[commit]; ok {
+			if _, storedAsLink := existingLinks[commit]; storedAsLink || !uploadRowsEqual(uploads, existingUploads[commit]) {
+				changed = append(changed, commit)
+			}
+			continue
+		}
+
+		if link, ok := linksByCommit[commit]; ok {
+			ancestor := ""
+			if link.Ancestor != nil {
+				ancestor = *link.Ancestor
+			}
+
+			existing, storedAsLink := existingLinks[commit]
+			_, storedAsUpload := existingUploads[commit]
+			if storedAsUpload || !storedAsLink || existing.ancestor != ancestor || existing.distance != link.AncestorDistance {
+				changed = append(changed, commit)
+			}
+			continue
+		}
+
+		// Gather() produced nothing at all for this commit; any row we previously stored for
+		// it is now stale.
+		if _, storedAsUpload := existingUploads[commit]; storedAsUpload {
+			changed = append(changed, commit)
+			continue
+		}
+		if _, storedAsLink := existingLinks[commit]; storedAsLink {
+			changed = append(changed, commit)
+		}
+	}
+
+	return changed, nil
+}
+
+// uploadRowsEqual reports whether fresh (from a Gather() call) and stored (decoded from
+// lsif_nearest_uploads) describe the same set of (upload, distance) pairs, ignoring order.
+func uploadRowsEqual(fresh []commitgraph.UploadMeta, stored []uploadRow) bool {
+	if len(fresh) != len(stored) {
+		return false
+	}
+
+	freshRows := make([]uploadRow, len(fresh))
+	for i, meta := range fresh {
+		freshRows[i] = uploadRow{uploadID: meta.UploadID, distance: meta.Flags & commitgraph.MaxDistance}
+	}
+
+	sortUploadRows := func(rows []uploadRow) {
+		sort.Slice(rows, func(i, j int) bool {
+			if rows[i].uploadID != rows[j].uploadID {
+				return rows[i].uploadID < rows[j].uploadID
+			}
+			return rows[i].distance < rows[j].distance
+		})
+	}
+	sortUploadRows(freshRows)
+	storedRows := append([]uploadRow(nil), stored...)
+	sortUploadRows(storedRows)
+
+	for i := range freshRows {
+		if freshRows[i] != storedRows[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// scanExistingVisibility reads every row currently stored for repositoryID in
+// lsif_nearest_uploads and lsif_nearest_uploads_links, decoding commit_bytea back to the hex
+// commit string Gather() keys its own maps by.
+func (s *Store) scanExistingVisibility(ctx context.Context, repositoryID int) (uploads map[string][]uploadRow, links map[string]linkRow, err error) {
+	uploads = map[string][]uploadRow{}
+
+	uploadRows, err := s.Store.Query(ctx, sqlf.Sprintf(`
+		SELECT encode(commit_bytea, 'hex'), upload_id, distance
+		FROM lsif_nearest_uploads
+		WHERE repository_id = %s
+	`, repositoryID))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { err = basestore.CloseRows(uploadRows, err) }()
+
+	for uploadRows.Next() {
+		var commit string
+		var row uploadRow
+		if err := uploadRows.Scan(&commit, &row.uploadID, &row.distance); err != nil {
+			return nil, nil, err
+		}
+		uploads[commit] = append(uploads[commit], row)
+	}
+
+	links = map[string]linkRow{}
+
+	linkRows, err := s.Store.Query(ctx, sqlf.Sprintf(`
+		SELECT encode(commit_bytea, 'hex'), encode(ancestor_commit_bytea, 'hex'), distance
+		FROM lsif_nearest_uploads_links
+		WHERE repository_id = %s
+	`, repositoryID))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { err = basestore.CloseRows(linkRows, err) }()
+
+	for linkRows.Next() {
+		var commit string
+		var row linkRow
+		if err := linkRows.Scan(&commit, &row.ancestor, &row.distance); err != nil {
+			return nil, nil, err
+		}
+		links[commit] = row
+	}
+
+	return uploads, links, nil
+}
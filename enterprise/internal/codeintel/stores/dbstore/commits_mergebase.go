@@ -0,0 +1,142 @@
+package dbstore
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/keegancsmith/sqlf"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/sourcegraph/sourcegraph/enterprise/internal/codeintel/commitgraph"
+	"github.com/sourcegraph/sourcegraph/enterprise/internal/codeintel/gitserver"
+	"github.com/sourcegraph/sourcegraph/internal/db/basestore"
+	"github.com/sourcegraph/sourcegraph/internal/db/dbutil"
+	"github.com/sourcegraph/sourcegraph/internal/observation"
+)
+
+// FindClosestUploadsAtMergeBase locates the merge base of commitA and commitB in commitGraph,
+// then returns the uploads visible from that merge base whose root is an ancestor directory of
+// path. This allows definition/hover requests made against a commit with no LSIF data of its
+// own (e.g. a freshly pushed feature branch) to fall back to the uploads visible where that
+// branch diverged from its target, rather than failing outright or guessing at the branch tip.
+//
+// commitGraph must contain both commitA and commitB; it is the caller's responsibility to have
+// fetched a graph wide enough to cover both, e.g. by unioning the graphs of the two branches.
+//
+// Exposing this through the code intel GraphQL resolvers (so hover/definition requests can opt
+// into merge-base semantics when the head commit has no upload of its own) is not done here:
+// the resolver that would call FindClosestUploadsAtMergeBase, and the schema field it would
+// answer, live in the graphqlbackend tree, which this snapshot doesn't carry. The call that
+// resolver would make is exactly this signature, with commitA set to the head commit and
+// commitB to the target branch's tip.
+func (s *Store) FindClosestUploadsAtMergeBase(ctx context.Context, repositoryID int, commitGraph *gitserver.CommitGraph, commitA, commitB, path string) (mergeBase string, _ []commitgraph.UploadMeta, err error) {
+	ctx, endObservation := s.operations.findClosestUploadsAtMergeBase.With(ctx, &err, observation.Args{LogFields: []log.Field{
+		log.Int("repositoryID", repositoryID),
+		log.String("commitA", commitA),
+		log.String("commitB", commitB),
+		log.String("path", path),
+	}})
+	defer func() {
+		endObservation(1, observation.Args{LogFields: []log.Field{log.String("mergeBase", mergeBase)}})
+	}()
+
+	tx, err := s.transact(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() { err = tx.Done(err) }()
+
+	commitGraphView, err := scanCommitGraphView(tx.Store.Query(ctx, sqlf.Sprintf(`
+		SELECT id, commit, md5(root || ':' || indexer) as token, 0 as distance
+		FROM lsif_uploads
+		WHERE state = 'completed' AND repository_id = %s
+	`, repositoryID)))
+	if err != nil {
+		return "", nil, err
+	}
+
+	graph := commitgraph.NewGraph(commitGraph, commitGraphView)
+
+	mergeBase, ok := graph.MergeBase(commitA, commitB)
+	if !ok {
+		return "", nil, nil
+	}
+
+	uploads, err := tx.uploadsVisibleAtCommitUnderPath(ctx, repositoryID, mergeBase, path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return mergeBase, uploads, nil
+}
+
+// uploadsVisibleAtCommitUnderPath returns the uploads that are recorded as nearest to commit
+// (directly via lsif_nearest_uploads, or transitively via lsif_nearest_uploads_links) and whose
+// root is an ancestor directory of path, ordered by increasing distance from commit.
+func (s *Store) uploadsVisibleAtCommitUnderPath(ctx context.Context, repositoryID int, commit, path string) (_ []commitgraph.UploadMeta, err error) {
+	rows, err := s.Store.Query(ctx, sqlf.Sprintf(`
+		WITH RECURSIVE lineage(ancestor_commit_bytea, distance) AS (
+			SELECT commit_bytea, 0
+			FROM lsif_nearest_uploads
+			WHERE repository_id = %s AND commit_bytea = %s
+
+			UNION
+
+			SELECT ancestor_commit_bytea, distance
+			FROM lsif_nearest_uploads_links
+			WHERE repository_id = %s AND commit_bytea = %s
+
+			UNION
+
+			SELECT l.ancestor_commit_bytea, lineage.distance + l.distance
+			FROM lineage
+			JOIN lsif_nearest_uploads_links l
+			ON l.repository_id = %s AND l.commit_bytea = lineage.ancestor_commit_bytea
+		)
+		SELECT u.upload_id, lu.root, MIN(lineage.distance + u.distance) AS distance
+		FROM lineage
+		JOIN lsif_nearest_uploads u ON u.repository_id = %s AND u.commit_bytea = lineage.ancestor_commit_bytea
+		JOIN lsif_uploads lu ON lu.id = u.upload_id
+		GROUP BY u.upload_id, lu.root
+		ORDER BY distance
+	`,
+		repositoryID, dbutil.CommitBytea(commit),
+		repositoryID, dbutil.CommitBytea(commit),
+		repositoryID,
+		repositoryID,
+	))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = basestore.CloseRows(rows, err) }()
+
+	return scanUploadMetasUnderPath(rows, path)
+}
+
+func scanUploadMetasUnderPath(rows *sql.Rows, path string) (_ []commitgraph.UploadMeta, err error) {
+	var uploads []commitgraph.UploadMeta
+	for rows.Next() {
+		var meta commitgraph.UploadMeta
+		var root string
+		if err := rows.Scan(&meta.UploadID, &root, &meta.Flags); err != nil {
+			return nil, err
+		}
+
+		if isPathUnderRoot(path, root) {
+			uploads = append(uploads, meta)
+		}
+	}
+
+	return uploads, nil
+}
+
+// isPathUnderRoot reports whether path is root itself or lies within the directory root names.
+// A plain strings.HasPrefix(path, root) would be wrong here: it would treat root "sub1" as
+// containing path "sub10/foo.go", since "sub10/foo.go" does start with the literal characters
+// "sub1" even though "sub10" is a sibling directory, not a child of "sub1".
+func isPathUnderRoot(path, root string) bool {
+	if root == "" || path == root {
+		return true
+	}
+	return strings.HasPrefix(path, root+"/")
+}
@@ -0,0 +1,24 @@
+package dbstore
+
+import "testing"
+
+func TestIsPathUnderRoot(t *testing.T) {
+	tests := []struct {
+		path string
+		root string
+		want bool
+	}{
+		{path: "a.go", root: "", want: true},
+		{path: "sub1/a.go", root: "sub1", want: true},
+		{path: "sub1", root: "sub1", want: true},
+		{path: "sub10/a.go", root: "sub1", want: false},
+		{path: "sub1", root: "sub10", want: false},
+		{path: "other/a.go", root: "sub1", want: false},
+	}
+
+	for _, tc := range tests {
+		if got := isPathUnderRoot(tc.path, tc.root); got != tc.want {
+			t.Errorf("isPathUnderRoot(%q, %q) = %v, want %v", tc.path, tc.root, got, tc.want)
+		}
+	}
+}
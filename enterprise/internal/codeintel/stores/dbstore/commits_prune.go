@@ -0,0 +1,95 @@
+package dbstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/keegancsmith/sqlf"
+	"github.com/lib/pq"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/sourcegraph/sourcegraph/internal/db/basestore"
+	"github.com/sourcegraph/sourcegraph/internal/db/dbutil"
+	"github.com/sourcegraph/sourcegraph/internal/observation"
+)
+
+// PruneOrphanedCommits deletes visibility rows for repositoryID that reference a commit no
+// longer reachable from any ref (branch or tag) in the repository, as determined by the caller
+// and supplied via reachableCommits. These rows otherwise accumulate forever: once a commit is
+// rewritten away by a rebase or force-push, CalculateVisibleUploads has no reason to ever visit
+// it again, so it can only be cleaned up by a sweep like this one.
+//
+// It returns the total number of rows removed across lsif_nearest_uploads,
+// lsif_nearest_uploads_links (both keyed by commit), and lsif_uploads_visible_at_tip (which
+// has no commit of its own, but can end up pointing at an upload that no longer exists once the
+// commits above are pruned out from under it).
+//
+// reachableCommits must be non-empty: an empty slice would make "NOT (commit_bytea = ANY(%s))"
+// true for every row in the repository, so a caller error, a race with the ref listing that
+// produced reachableCommits, or a transient zero-ref repository would otherwise wipe out every
+// visibility row for repositoryID instead of pruning the stale ones. PruneOrphanedCommits
+// refuses to run in that case and returns an error instead of guessing.
+func (s *Store) PruneOrphanedCommits(ctx context.Context, repositoryID int, reachableCommits []string) (count int, err error) {
+	ctx, endObservation := s.operations.pruneOrphanedCommits.With(ctx, &err, observation.Args{LogFields: []log.Field{
+		log.Int("repositoryID", repositoryID),
+		log.Int("numReachableCommits", len(reachableCommits)),
+	}})
+	defer func() {
+		endObservation(1, observation.Args{LogFields: []log.Field{log.Int("count", count)}})
+	}()
+
+	if len(reachableCommits) == 0 {
+		return 0, fmt.Errorf("dbstore: refusing to prune repository %d: reachableCommits is empty, which would delete every visibility row", repositoryID)
+	}
+
+	reachable := make([][]byte, len(reachableCommits))
+	for i, commit := range reachableCommits {
+		reachable[i] = dbutil.CommitBytea(commit)
+	}
+
+	tx, err := s.transact(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { err = tx.Done(err) }()
+
+	deleteUnreachable := func(table string) (int, bool, error) {
+		return basestore.ScanFirstInt(tx.Store.Query(ctx, sqlf.Sprintf(
+			`WITH deleted AS (
+				DELETE FROM `+table+`
+				WHERE repository_id = %s AND NOT (commit_bytea = ANY(%s))
+				RETURNING 1
+			)
+			SELECT COUNT(*) FROM deleted`,
+			repositoryID,
+			pq.Array(reachable),
+		)))
+	}
+
+	for _, table := range []string{"lsif_nearest_uploads", "lsif_nearest_uploads_links"} {
+		n, _, err := deleteUnreachable(table)
+		if err != nil {
+			return count, err
+		}
+		count += n
+	}
+
+	// lsif_uploads_visible_at_tip has no commit_bytea of its own, so it is pruned by upload
+	// instead: rows whose upload is no longer a completed upload of this repository are
+	// deleted. Which commits were pruned above does not enter into it.
+	n, _, err := basestore.ScanFirstInt(tx.Store.Query(ctx, sqlf.Sprintf(`
+		WITH deleted AS (
+			DELETE FROM lsif_uploads_visible_at_tip
+			WHERE repository_id = %s AND upload_id NOT IN (
+				SELECT id FROM lsif_uploads WHERE repository_id = %s AND state = 'completed'
+			)
+			RETURNING 1
+		)
+		SELECT COUNT(*) FROM deleted
+	`, repositoryID, repositoryID)))
+	if err != nil {
+		return count, err
+	}
+	count += n
+
+	return count, nil
+}
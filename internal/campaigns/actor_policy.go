@@ -0,0 +1,26 @@
+package campaigns
+
+// ActorPolicyMode is the effect a CampaignActorPolicy row has on the actor it matches.
+type ActorPolicyMode string
+
+const (
+	// ActorPolicyModeBlock marks the matching actor as blocked (see IsAuthorBlocked in the
+	// campaigns store). The changeset sync and stats queries do not consult actor policies.
+	ActorPolicyModeBlock ActorPolicyMode = "BLOCK"
+	// ActorPolicyModeAllow takes precedence over an ActorPolicyModeBlock row for the same
+	// actor. Policies match one login exactly; there is no campaign-wide block for it to
+	// override.
+	ActorPolicyModeAllow ActorPolicyMode = "ALLOW"
+)
+
+// CampaignActorPolicy is a per-campaign allow/block rule keyed on a code-host author login,
+// mirroring the author-blocking pattern common to forge moderation tooling: campaign owners
+// use it to keep bot-opened or spam PRs from cluttering a campaign's changeset list without
+// having to detach them manually.
+type CampaignActorPolicy struct {
+	ID                  int64
+	CampaignID          int64
+	ExternalServiceType string
+	ExternalLogin       string
+	Mode                ActorPolicyMode
+}
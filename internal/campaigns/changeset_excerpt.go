@@ -0,0 +1,33 @@
+package campaigns
+
+import (
+	"time"
+
+	"github.com/sourcegraph/sourcegraph/internal/api"
+)
+
+// ChangesetExcerpt is a denormalized, list-friendly projection of a Changeset: just the
+// columns a connection resolver needs to render a row, without the large JSONB Metadata blob
+// a full Changeset carries, so that List queries never have to decode code-host metadata just
+// to show a title and a state. Its rows are written by the campaigns store (see
+// RebuildChangesetExcerpts); CreateChangeset and UpdateChangeset do not maintain them.
+type ChangesetExcerpt struct {
+	ID               int64
+	RepoID           api.RepoID
+	Title            string
+	AuthorLogin      string
+	ExternalState    ChangesetExternalState
+	ReviewState      ChangesetReviewState
+	CheckState       ChangesetCheckState
+	PublicationState ChangesetPublicationState
+	ReconcilerState  ReconcilerState
+	UpdatedAt        time.Time
+	NumComments      int32
+	CampaignIDs      []int64
+	Labels           []string
+
+	// EditLamport is the owning campaign's Lamport clock value as of the most recent write to
+	// this changeset. See ChangesetOperation.EditLamport for why this is a Lamport counter
+	// rather than a wall-clock timestamp.
+	EditLamport int64
+}
@@ -0,0 +1,16 @@
+package campaigns
+
+import "time"
+
+// ChangesetIssueLink records that a changeset's body references an issue or pull request
+// using a GitHub/GitLab-style closing keyword (e.g. "fixes #123"), and so would close that
+// issue if the changeset merges. It is derived data, recomputed from the changeset's body by
+// the campaigns store's RecomputeChangesetIssueLinks, which creating or updating a changeset
+// does not call; see ParseClosingKeywordIssueRefs.
+type ChangesetIssueLink struct {
+	ID                  int64
+	ChangesetID         int64
+	ExternalServiceID   string
+	ExternalIssueNumber int64
+	CreatedAt           time.Time
+}
@@ -0,0 +1,23 @@
+package campaigns
+
+// ChangesetLabel is a user- or sync-defined label that can be attached to changesets, borrowed
+// from the Gitea issue-label model: a name, a display color, and an optional description.
+// CampaignID is 0 for a label that is available campaign-wide (an "org label"); otherwise the
+// label only applies within that one campaign.
+type ChangesetLabel struct {
+	ID          int64
+	CampaignID  int64
+	Name        string
+	Color       string
+	Description string
+}
+
+// ChangesetCounts is a breakdown of changeset external states. Keyed by label ID, it is the
+// value type of the map the campaigns store's GetLabelStats returns; no PerLabel field of the
+// changeset stats carries it.
+type ChangesetCounts struct {
+	Open    int64
+	Closed  int64
+	Merged  int64
+	Deleted int64
+}
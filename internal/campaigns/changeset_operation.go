@@ -0,0 +1,133 @@
+package campaigns
+
+import "time"
+
+// ChangesetOperationType describes the kind of change a ChangesetOperation records.
+type ChangesetOperationType string
+
+const (
+	// ChangesetOperationCreate marks the point a changeset was first created.
+	ChangesetOperationCreate ChangesetOperationType = "CREATE"
+	// ChangesetOperationSetTitle records a change to the changeset's title.
+	ChangesetOperationSetTitle ChangesetOperationType = "SET_TITLE"
+	// ChangesetOperationSetStatus records a change to the changeset's external, review, or
+	// check state.
+	ChangesetOperationSetStatus ChangesetOperationType = "SET_STATUS"
+	// ChangesetOperationAddComment records a comment being added to the changeset.
+	ChangesetOperationAddComment ChangesetOperationType = "ADD_COMMENT"
+	// ChangesetOperationLabelChange records a label being added to or removed from the
+	// changeset.
+	ChangesetOperationLabelChange ChangesetOperationType = "LABEL_CHANGE"
+	// ChangesetOperationSetMetadata records a wholesale replacement of the changeset's
+	// code-host metadata, e.g. after a sync picks up upstream edits this store didn't make.
+	ChangesetOperationSetMetadata ChangesetOperationType = "SET_METADATA"
+	// ChangesetOperationSync records that a sync ran and found no changes worth recording as
+	// a more specific operation above.
+	ChangesetOperationSync ChangesetOperationType = "SYNC"
+	// ChangesetOperationEnqueueClose records that the reconciler was asked to close the
+	// changeset: it resets the changeset back onto the queue with Closing set.
+	ChangesetOperationEnqueueClose ChangesetOperationType = "ENQUEUE_CLOSE"
+	// ChangesetOperationMarkFailed records a reconciler attempt failing, carrying the error
+	// message and the resulting failure count.
+	ChangesetOperationMarkFailed ChangesetOperationType = "MARK_FAILED"
+	// ChangesetOperationSetReconcilerState records the reconciler moving the changeset from
+	// one ReconcilerState to another (e.g. queued -> processing -> completed).
+	ChangesetOperationSetReconcilerState ChangesetOperationType = "SET_RECONCILER_STATE"
+	// ChangesetOperationSetPublicationState records the changeset moving from one
+	// ChangesetPublicationState to another.
+	ChangesetOperationSetPublicationState ChangesetOperationType = "SET_PUBLICATION_STATE"
+)
+
+// ChangesetOperation is a single immutable entry in a changeset's append-only history. Ops
+// are chained via PreviousOpID so that ReplayChangeset can fold them, in order, into a
+// snapshot equivalent to the changeset's current row.
+type ChangesetOperation struct {
+	ID           int64
+	ChangesetID  int64
+	Type         ChangesetOperationType
+	AuthorID     int32
+	CreatedAt    time.Time
+	Payload      ChangesetOperationPayload
+	PreviousOpID int64
+
+	// EditLamport is the value of the owning campaign's Lamport clock at the moment this op
+	// was recorded, used to order and deduplicate edits made by multiple syncers, the
+	// reconciler, and user actions without relying on wall-clock timestamps, which can be
+	// skewed across processes.
+	EditLamport int64
+}
+
+// ChangesetOperationPayload is the op-specific data carried by a ChangesetOperation. Exactly
+// one field is set, matching the op's Type.
+type ChangesetOperationPayload struct {
+	Title            *ChangesetOperationSetTitlePayload            `json:"title,omitempty"`
+	Status           *ChangesetOperationSetStatusPayload           `json:"status,omitempty"`
+	Comment          *ChangesetOperationAddCommentPayload          `json:"comment,omitempty"`
+	Label            *ChangesetOperationLabelChangePayload         `json:"label,omitempty"`
+	Metadata         *ChangesetOperationSetMetadataPayload         `json:"metadata,omitempty"`
+	MarkFailed       *ChangesetOperationMarkFailedPayload          `json:"mark_failed,omitempty"`
+	ReconcilerState  *ChangesetOperationSetReconcilerStatePayload  `json:"reconciler_state,omitempty"`
+	PublicationState *ChangesetOperationSetPublicationStatePayload `json:"publication_state,omitempty"`
+}
+
+// ChangesetOperationSetTitlePayload is the payload of a ChangesetOperationSetTitle op.
+type ChangesetOperationSetTitlePayload struct {
+	Previous string `json:"previous"`
+	Current  string `json:"current"`
+}
+
+// ChangesetOperationSetStatusPayload is the payload of a ChangesetOperationSetStatus op.
+type ChangesetOperationSetStatusPayload struct {
+	PreviousExternalState ChangesetExternalState `json:"previous_external_state"`
+	CurrentExternalState  ChangesetExternalState `json:"current_external_state"`
+	PreviousReviewState   ChangesetReviewState   `json:"previous_review_state"`
+	CurrentReviewState    ChangesetReviewState   `json:"current_review_state"`
+	PreviousCheckState    ChangesetCheckState    `json:"previous_check_state"`
+	CurrentCheckState     ChangesetCheckState    `json:"current_check_state"`
+}
+
+// ChangesetOperationAddCommentPayload is the payload of a ChangesetOperationAddComment op.
+type ChangesetOperationAddCommentPayload struct {
+	Body string `json:"body"`
+}
+
+// ChangesetOperationLabelChangePayload is the payload of a ChangesetOperationLabelChange op.
+type ChangesetOperationLabelChangePayload struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// ChangesetOperationSetMetadataPayload is the payload of a ChangesetOperationSetMetadata op.
+// Metadata has room for the code host's own JSON representation (a github.PullRequest or
+// gitlab.MergeRequest, for example), but diffChangesetOperations records only
+// ExternalServiceType and leaves Metadata empty, and ReplayChangeset does not assign anything
+// back to Changeset.Metadata.
+type ChangesetOperationSetMetadataPayload struct {
+	ExternalServiceType string                        `json:"external_service_type"`
+	Metadata            ChangesetOperationRawMetadata `json:"metadata"`
+}
+
+// ChangesetOperationRawMetadata is the undecoded JSON of a code-host metadata value, decoded
+// by the caller once ExternalServiceType is known (the same way Changeset.Metadata itself is
+// unmarshaled today).
+type ChangesetOperationRawMetadata []byte
+
+// ChangesetOperationMarkFailedPayload is the payload of a ChangesetOperationMarkFailed op.
+type ChangesetOperationMarkFailedPayload struct {
+	FailureMessage string `json:"failure_message"`
+	NumFailures    int64  `json:"num_failures"`
+}
+
+// ChangesetOperationSetReconcilerStatePayload is the payload of a
+// ChangesetOperationSetReconcilerState op.
+type ChangesetOperationSetReconcilerStatePayload struct {
+	Previous ReconcilerState `json:"previous"`
+	Current  ReconcilerState `json:"current"`
+}
+
+// ChangesetOperationSetPublicationStatePayload is the payload of a
+// ChangesetOperationSetPublicationState op.
+type ChangesetOperationSetPublicationStatePayload struct {
+	Previous ChangesetPublicationState `json:"previous"`
+	Current  ChangesetPublicationState `json:"current"`
+}